@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+// These benchmarks need a live RethinkDB, see testSession. Each iteration
+// inserts benchRecords records into a scratch table that is dropped when the
+// benchmark finishes:
+//
+//	RETHINKDB_ADDR=localhost:28015 go test -run '^$' -bench Insert
+//
+// Expect the batch insert to be roughly two orders of magnitude faster per
+// iteration: the loop pays one round trip (and one durable write) per record,
+// the batch pays one for the whole slice.
+const (
+	benchTable   = "geospatial_bench"
+	benchRecords = 100
+)
+
+func BenchmarkInsertSingle(b *testing.B) {
+	session := testSession(b)
+	batch := benchBatch()
+	createBenchTable(b, session)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, record := range batch {
+			if _, err := r.DB(DBName).Table(benchTable).Insert(record).RunWrite(session); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkInsertBatch(b *testing.B) {
+	session := testSession(b)
+	batch := benchBatch()
+	createBenchTable(b, session)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.DB(DBName).Table(benchTable).Insert(batch).RunWrite(session); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchBatch repeats the sample records until there are benchRecords of them.
+func benchBatch() []Record {
+	batch := make([]Record, benchRecords)
+	for i := range batch {
+		batch[i] = records[i%len(records)]
+	}
+	return batch
+}
+
+// createBenchTable recreates the scratch table and drops it again when b is
+// done.
+func createBenchTable(b *testing.B, session *r.Session) {
+	r.DB(DBName).TableDrop(benchTable).Exec(session)
+	if err := r.DB(DBName).TableCreate(benchTable).Exec(session); err != nil {
+		b.Fatal("Cannot create table: ", err)
+	}
+	b.Cleanup(func() {
+		if err := r.DB(DBName).TableDrop(benchTable).Exec(session); err != nil {
+			b.Log("Cannot drop table: ", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+// tableIndexes are the indexes of the records table, each created by its
+// ensure func unless it already exists.
+var tableIndexes = []struct {
+	name   string
+	ensure func(session *r.Session) error
+}{
+	{indexName, func(session *r.Session) error { return ensureGeoIndex(session, tableName, indexName, indexName) }},
+	{categoryIndex, func(session *r.Session) error { return ensureIndex(session, tableName, categoryIndex) }},
+	{nameIndex, func(session *r.Session) error { return ensureIndex(session, tableName, nameIndex) }},
+	{updatedIndex, func(session *r.Session) error { return ensureIndexFunc(session, tableName, updatedIndex, modifiedAt) }},
+	{layerIndex, func(session *r.Session) error { return ensureIndex(session, tableName, layerIndex) }},
+}
+
+// BootstrapError is returned by bootstrap with the step that failed, such as
+// "create database" or "create index area".
+type BootstrapError struct {
+	Step string
+	Err  error
+}
+
+func (e *BootstrapError) Error() string {
+	return fmt.Sprintf("bootstrap: cannot %s: %v", e.Step, e.Err)
+}
+
+func (e *BootstrapError) Unwrap() error { return e.Err }
+
+// bootstrap makes sure the database, the records table keyed on
+// -primary-key and all of tableIndexes exist, then waits until every index
+// of the table is ready. Anything already there is kept as it is, data
+// included, so it is safe to run on every startup. A table created earlier
+// with another primary key keeps its key.
+func bootstrap(session *r.Session) error {
+	if err := ensureDatabase(session, DBName); err != nil {
+		return &BootstrapError{Step: "create database", Err: err}
+	}
+	exists, err := hasTable(session, tableName)
+	if err == nil && !exists {
+		err = r.DB(DBName).TableCreate(tableName, r.TableCreateOpts{
+			PrimaryKey: *primaryKey,
+		}).Exec(session)
+	}
+	if err != nil {
+		return &BootstrapError{Step: "create table", Err: err}
+	}
+	for _, index := range tableIndexes {
+		if err := index.ensure(session); err != nil {
+			return &BootstrapError{Step: "create index " + index.name, Err: err}
+		}
+	}
+	if err := r.DB(DBName).Table(tableName).IndexWait().Exec(session); err != nil {
+		return &BootstrapError{Step: "wait for indexes", Err: err}
+	}
+	return nil
+}
+
+// ensureDatabase creates the database name unless it already exists.
+func ensureDatabase(session *r.Session, name string) error {
+	var names []string
+	res, err := runQuery(session, r.DBList())
+	if err != nil {
+		return err
+	}
+	if err = res.All(&names); err != nil {
+		return err
+	}
+	for _, n := range names {
+		if n == name {
+			return nil
+		}
+	}
+	return r.DBCreate(name).Exec(session)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+const (
+	cacheTable = "nearest_cache"
+
+	// cachePrecision is how many decimals query points are rounded to for
+	// the cache key, 4 being about 11m: queries closer together than that
+	// share an entry.
+	cachePrecision = 4
+)
+
+// cacheEntry is a nearest_cache document.
+type cacheEntry struct {
+	ID          string                `gorethink:"id"`
+	Records     []*RecordWithDistance `gorethink:"records"`
+	RefreshedAt time.Time             `gorethink:"refreshed_at"`
+}
+
+// cacheKey identifies the cached result of the query opts around p. The
+// options are part of the key, so an entry only ever answers the exact query
+// it was computed for.
+func cacheKey(p types.Point, opts r.GetNearestOpts) string {
+	p = roundPoint(p, cachePrecision)
+	return fmt.Sprintf("%v,%v|%v|%v|%v|%v", p.Lon, p.Lat, opts.Index, opts.MaxDist, opts.MaxResults, opts.Unit)
+}
+
+// refreshCache computes the nearest results around each of points, rounded
+// to cachePrecision, and replaces their nearest_cache entries, creating the
+// table if needed. Each entry is computed and written by a single query on
+// the server, so the results never travel to the client. Run it periodically
+// for the hot points: entries are only as fresh as the last refresh.
+func refreshCache(session *r.Session, points []types.Point, opts r.GetNearestOpts) error {
+	exists, err := hasTable(session, cacheTable)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := r.DB(DBName).TableCreate(cacheTable).Exec(session); err != nil {
+			return fmt.Errorf("cannot create table: %v", err)
+		}
+	}
+	for _, p := range points {
+		q := roundPoint(p, cachePrecision)
+		entry := map[string]interface{}{
+			"id":           cacheKey(p, opts),
+			"records":      r.Table(tableName).GetNearest(q, opts).Filter(resultNotDeleted()).CoerceTo("array"),
+			"refreshed_at": r.Now(),
+		}
+		if err := r.Table(cacheTable).Insert(entry, r.InsertOpts{Conflict: "replace"}).Exec(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCached answers the nearest query opts around p from nearest_cache, or
+// with a live nearest query when there is no entry for it, the entry is older
+// than maxAge (0 accepts any age) or the table has not been created yet by a
+// refreshCache. The bool reports whether the result
+// came from the cache.
+//
+// The price of a hit is staleness: a cached result is what the table held at
+// the last refresh, so records inserted, moved or soft-deleted since are
+// missing or out of date until the next one. The query point is also
+// rounded, so distances are from a point up to about 8m away from p. Only
+// cache points where that is acceptable.
+func getCached(session *r.Session, p types.Point, opts r.GetNearestOpts, maxAge time.Duration) (*NearestResult, bool, error) {
+	var entry cacheEntry
+	res, err := runQuery(session, r.Table(cacheTable).Get(cacheKey(p, opts)))
+	if err == nil {
+		err = res.One(&entry)
+	}
+	if err != nil && !errors.Is(err, r.ErrEmptyResult) && !isTableMissing(err) {
+		return nil, false, err
+	}
+	if err == nil && (maxAge == 0 || time.Since(entry.RefreshedAt) <= maxAge) {
+		return newNearestResult(roundPoint(p, cachePrecision), opts, entry.Records), true, nil
+	}
+	index, _ := opts.Index.(string)
+	live, err := nearest(session, index, p, opts)
+	return live, false, err
+}
+
+// isTableMissing reports whether err is the server refusing a query because
+// its table does not exist. The driver has no error type for it, only the
+// message.
+func isTableMissing(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Table `") && strings.Contains(msg, "` does not exist")
+}
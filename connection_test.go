@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+// closedSession is a session that is closed until Reconnect is called.
+type closedSession struct {
+	connected  atomic.Bool
+	reconnects atomic.Int32
+}
+
+func (s *closedSession) IsConnected() bool { return s.connected.Load() }
+
+func (s *closedSession) Reconnect(...r.CloseOpts) error {
+	s.reconnects.Add(1)
+	s.connected.Store(true)
+	return nil
+}
+
+func TestReconnectClosedSessionOnce(t *testing.T) {
+	s := new(closedSession)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := reconnect(s); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.reconnects.Load(); n != 1 {
+		t.Errorf("reopened the session %d times, want 1", n)
+	}
+}
+
+func TestReconnectLeavesConnectedSession(t *testing.T) {
+	s := new(closedSession)
+	s.connected.Store(true)
+	if err := reconnect(s); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.reconnects.Load(); n != 0 {
+		t.Errorf("reopened a connected session %d times, want 0", n)
+	}
+}
+
+func TestRunQueryAfterClose(t *testing.T) {
+	session := testSession(t)
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	res, err := runQuery(session, r.Expr(1))
+	if err != nil {
+		t.Fatal("query on a closed session: ", err)
+	}
+	if err := res.One(&n); err != nil || n != 1 {
+		t.Errorf("got %d, %v, want 1", n, err)
+	}
+}
+
+func TestIsHandshakeRejected(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New(`gorethink: Server dropped connection with message: "ERROR: Received an unsupported protocol version. This port is for RethinkDB queries."`), true},
+		{io.EOF, false},
+		{fmt.Errorf("gorethink: %w", io.ErrUnexpectedEOF), false},
+		{errors.New("dial tcp 127.0.0.1:28015: connect: connection refused"), false},
+		{nil, false},
+	} {
+		if got := isHandshakeRejected(tc.err); got != tc.want {
+			t.Errorf("isHandshakeRejected(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/encoding"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// ErrGeometryTypeMismatch is what a GeometryTypeMismatchError matches with
+// errors.Is.
+var ErrGeometryTypeMismatch = errors.New("geometry is not of the expected type")
+
+// GeometryTypeMismatchError is returned when a document's area is not a
+// point, such as a region polygon stored in the same table, but is decoded
+// into a Record, whose area is a types.Point. The driver's own error for it
+// names neither the document nor the type it found.
+type GeometryTypeMismatchError struct {
+	ID   string
+	Type string
+}
+
+func (e *GeometryTypeMismatchError) Error() string {
+	return fmt.Sprintf("document %q has a %s area where a Point was expected", e.ID, e.Type)
+}
+
+func (e *GeometryTypeMismatchError) Unwrap() error { return ErrGeometryTypeMismatch }
+
+// decodeAll is res.All for rows that are records or {dist, doc} nearest rows:
+// it reads the rows raw and decodes them one by one with decodeRow, so a
+// row that fails to decode can be looked at. It closes res.
+func decodeAll[T any](res *r.Cursor, rows *[]T) error {
+	defer res.Close()
+	for {
+		var row T
+		ok, err := nextRow(res, &row)
+		if !ok {
+			return err
+		}
+		*rows = append(*rows, row)
+	}
+}
+
+// nextRow is res.Next for the rows decodeAll takes, for callers that stream
+// them: it reads the next row raw and decodes it into dst with decodeRow.
+// It returns false at the end of the rows or on an error, which is then
+// res.Err() or the decode failure.
+func nextRow(res *r.Cursor, dst interface{}) (bool, error) {
+	var raw interface{}
+	if !res.Next(&raw) {
+		return false, res.Err()
+	}
+	if err := decodeRow(raw, dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// decodeRows is decodeAll for rows that were read already, such as an array
+// field of a single result.
+func decodeRows[T any](raw []interface{}) ([]T, error) {
+	rows := make([]T, 0, len(raw))
+	for _, v := range raw {
+		var row T
+		if err := decodeRow(v, &row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// decodeRow decodes the raw row into dst. When that fails because the row's
+// area is a geometry other than a point, the error is a
+// GeometryTypeMismatchError; for other failures it is the driver's.
+func decodeRow(raw, dst interface{}) error {
+	err := encoding.Decode(dst, raw)
+	if err == nil {
+		return nil
+	}
+	row, _ := raw.(map[string]interface{})
+	doc := row
+	if nested, ok := row["doc"].(map[string]interface{}); ok {
+		doc = nested
+	}
+	var typ string
+	switch area := doc[indexName].(type) {
+	case types.Geometry:
+		typ = area.Type
+	case map[string]interface{}:
+		// Geometry still in its $reql_type$ form.
+		typ, _ = area["type"].(string)
+	}
+	if typ == "" || typ == "Point" {
+		return err
+	}
+	return &GeometryTypeMismatchError{ID: fmt.Sprint(doc[*primaryKey]), Type: typ}
+}
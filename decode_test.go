@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+func TestDecodeRowPolygonIntoPoint(t *testing.T) {
+	polygon := types.Geometry{Type: "Polygon", Lines: types.Lines{{{Lon: 0, Lat: 0}, {Lon: 1, Lat: 0}, {Lon: 1, Lat: 1}, {Lon: 0, Lat: 0}}}}
+	tests := []struct {
+		name string
+		raw  interface{}
+		dst  interface{}
+	}{
+		{"record", map[string]interface{}{"id": "r1", "area": polygon}, new(Record)},
+		{"nearest row", map[string]interface{}{"dist": 1.5, "doc": map[string]interface{}{"id": "r1", "area": polygon}}, new(RecordWithDistance)},
+		{"wire form", map[string]interface{}{"id": "r1", "area": map[string]interface{}{
+			"$reql_type$": "GEOMETRY",
+			"type":        "Polygon",
+			"coordinates": []interface{}{[]interface{}{[]interface{}{0.0, 0.0}, []interface{}{1.0, 0.0}, []interface{}{1.0, 1.0}, []interface{}{0.0, 0.0}}},
+		}}, new(Record)},
+	}
+	for _, tt := range tests {
+		err := decodeRow(tt.raw, tt.dst)
+		var mismatch *GeometryTypeMismatchError
+		if !errors.As(err, &mismatch) || !errors.Is(err, ErrGeometryTypeMismatch) {
+			t.Errorf("%s: got %v, want a GeometryTypeMismatchError", tt.name, err)
+			continue
+		}
+		if mismatch.ID != "r1" || mismatch.Type != "Polygon" {
+			t.Errorf("%s: got id %q, type %q, want r1, Polygon", tt.name, mismatch.ID, mismatch.Type)
+		}
+	}
+}
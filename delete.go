@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// Records are soft-deleted: softDelete sets their deleted flag and the
+// nearest queries filter flagged records out after GetNearest. The extra
+// filter is one field check per candidate, cheap next to the geo lookup, but
+// deleted records still take up slots in MaxResults.
+
+// softDelete marks the record with the given id as deleted.
+func softDelete(session *r.Session, id string) error {
+	resp, err := r.Table(tableName).Get(id).Update(map[string]interface{}{
+		"deleted":    true,
+		"updated_at": r.Now(),
+	}).RunWrite(session)
+	if err == nil && resp.Skipped > 0 {
+		err = fmt.Errorf("no record with id %q", id)
+	}
+	logWrite("delete", id, err)
+	return err
+}
+
+// Records carry created_at and updated_at timestamps. Both are set with
+// r.Now() in the write itself, so they are the server's clock rather than
+// whichever client wrote: insertRecords sets created_at, and moveRecord and
+// softDelete set updated_at. Records never updated have no updated_at.
+
+// moveRecord moves the record with the given id to p.
+func moveRecord(session *r.Session, id string, p types.Point) error {
+	resp, err := r.Table(tableName).Get(id).Update(map[string]interface{}{
+		indexName:    p,
+		"updated_at": r.Now(),
+	}).RunWrite(session)
+	if err == nil && resp.Skipped > 0 {
+		err = fmt.Errorf("no record with id %q", id)
+	}
+	logWrite("update", id, err)
+	return err
+}
+
+// modifiedAt is the updated_at index function: updated_at, or created_at for
+// a record that was never updated, so new records show up in modifiedSince
+// too. Records with neither are left out of the index.
+func modifiedAt(row r.Term) interface{} {
+	return row.Field("updated_at").Default(row.Field("created_at"))
+}
+
+// modifiedSince returns the records modified strictly after since, oldest
+// change first, for incremental sync. The bound is exclusive: pass the
+// updated_at (or created_at) of the last record of the previous sync and it
+// isn't returned again. The catch is a record written in the same
+// millisecond as that last one but after the sync read it, which the next
+// sync misses; r.Now() only has millisecond resolution. Soft-deleted
+// records are included, with deleted set, so a sync can remove them too.
+func modifiedSince(session *r.Session, since time.Time) ([]*Record, error) {
+	var rows []*Record
+	query := r.Table(tableName).
+		Between(since, r.MaxVal, r.BetweenOpts{Index: updatedIndex, LeftBound: "open"}).
+		OrderBy(r.OrderByOpts{Index: r.Asc(updatedIndex)})
+	res, err := runQuery(session, query)
+	if err != nil {
+		return nil, err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// notDeleted filters out soft-deleted records. Records written before the
+// flag existed have no deleted field and count as not deleted.
+func notDeleted() r.Term {
+	return r.Row.Field("deleted").Default(false).Eq(false)
+}
+
+// resultNotDeleted is notDeleted for GetNearest rows, which wrap the record
+// in doc.
+func resultNotDeleted() r.Term {
+	return r.Row.Field("doc").Field("deleted").Default(false).Eq(false)
+}
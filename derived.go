@@ -0,0 +1,38 @@
+package main
+
+import (
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// materializeNearest runs a nearest query on index around p and stores every
+// result in outTable, which must already exist, as {dist, doc, query, unit}.
+// The insert is chained with ForEach, so the rows go from the query into
+// outTable on the server and never travel to the client. It returns how many
+// rows were inserted.
+func materializeNearest(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, outTable string) (int, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return 0, err
+	}
+	opts.Index = index
+	unit := opts.Unit
+	if unit == nil {
+		unit = defaultUnit
+	}
+	resp, err := r.Table(tableName).
+		GetNearest(p, opts).
+		Filter(resultNotDeleted()).
+		ForEach(func(row r.Term) interface{} {
+			return r.Table(outTable).Insert(map[string]interface{}{
+				"dist":  row.Field("dist"),
+				"doc":   row.Field("doc"),
+				"query": p,
+				"unit":  unit,
+			})
+		}).
+		RunWrite(session)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Inserted, nil
+}
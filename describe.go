@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+type tableInfo struct {
+	Name       string `gorethink:"name"`
+	PrimaryKey string `gorethink:"primary_key"`
+}
+
+// describeTable prints the table's primary key, its secondary indexes with
+// the geo ones flagged, the document count and a couple of sample documents.
+func describeTable(session *r.Session, out io.Writer, table string) error {
+	t := r.DB(DBName).Table(table)
+
+	var info tableInfo
+	res, err := runQuery(session, t.Info())
+	if err != nil {
+		return err
+	}
+	if err = res.One(&info); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "table %s\n", info.Name)
+	fmt.Fprintf(out, "primary key: %s\n", info.PrimaryKey)
+
+	var statuses []IndexStatus
+	res, err = runQuery(session, t.IndexStatus())
+	if err != nil {
+		return err
+	}
+	if err = res.All(&statuses); err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		fmt.Fprintln(out, "secondary indexes: none")
+	} else {
+		fmt.Fprintln(out, "secondary indexes:")
+	}
+	for _, status := range statuses {
+		flags := ""
+		if status.Geo {
+			flags += " (geo)"
+		}
+		if status.Multi {
+			flags += " (multi)"
+		}
+		if !status.Ready {
+			flags += " (building)"
+		}
+		fmt.Fprintf(out, "  %s%s\n", status.Index, flags)
+	}
+
+	var count int
+	res, err = runQuery(session, t.Count())
+	if err != nil {
+		return err
+	}
+	if err = res.One(&count); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "documents: %d\n", count)
+	if count == 0 {
+		return nil
+	}
+
+	var samples []map[string]interface{}
+	res, err = runQuery(session, t.Limit(2))
+	if err != nil {
+		return err
+	}
+	if err = res.All(&samples); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "sample documents:")
+	for _, doc := range samples {
+		printStructAsJSON(out, doc)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+// diffResults compares two nearest results by record id, typically the same
+// query before and after changing MaxDist or MaxResults: added are the
+// records only in b, removed those only in a, and distChanged the rows of b
+// whose record is in both with a different distance. added and distChanged
+// are in b's order, removed in a's. Both results must come from queries in
+// the same unit, and ids need the default -primary-key.
+func diffResults(a, b []*RecordWithDistance) (added, removed []*Record, distChanged []*RecordWithDistance) {
+	inA := make(map[string]*RecordWithDistance, len(a))
+	for _, row := range a {
+		inA[row.Doc.ID] = row
+	}
+	inB := make(map[string]bool, len(b))
+	for _, row := range b {
+		inB[row.Doc.ID] = true
+		prev, ok := inA[row.Doc.ID]
+		switch {
+		case !ok:
+			added = append(added, row.Doc)
+		case prev.Dist != row.Dist:
+			distChanged = append(distChanged, row)
+		}
+	}
+	for _, row := range a {
+		if !inB[row.Doc.ID] {
+			removed = append(removed, row.Doc)
+		}
+	}
+	return added, removed, distChanged
+}
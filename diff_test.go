@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffResults(t *testing.T) {
+	row := func(id string, dist float64) *RecordWithDistance {
+		return &RecordWithDistance{Dist: dist, Doc: &Record{ID: id}}
+	}
+	ids := func(recs []*Record) []string {
+		var out []string
+		for _, rec := range recs {
+			out = append(out, rec.ID)
+		}
+		return out
+	}
+	rowIDs := func(rows []*RecordWithDistance) []string {
+		var out []string
+		for _, row := range rows {
+			out = append(out, row.Doc.ID)
+		}
+		return out
+	}
+	tests := []struct {
+		name                        string
+		a, b                        []*RecordWithDistance
+		added, removed, distChanged []string
+	}{
+		{
+			name:        "overlapping",
+			a:           []*RecordWithDistance{row("x", 1), row("y", 2), row("z", 3)},
+			b:           []*RecordWithDistance{row("w", 0.5), row("y", 2), row("x", 1.5), row("v", 4)},
+			added:       []string{"w", "v"},
+			removed:     []string{"z"},
+			distChanged: []string{"x"},
+		},
+		{
+			name:    "disjoint",
+			a:       []*RecordWithDistance{row("x", 1), row("y", 2)},
+			b:       []*RecordWithDistance{row("u", 1), row("v", 2)},
+			added:   []string{"u", "v"},
+			removed: []string{"x", "y"},
+		},
+		{
+			name: "identical",
+			a:    []*RecordWithDistance{row("x", 1), row("y", 2)},
+			b:    []*RecordWithDistance{row("x", 1), row("y", 2)},
+		},
+	}
+	for _, tt := range tests {
+		added, removed, distChanged := diffResults(tt.a, tt.b)
+		if got := ids(added); !reflect.DeepEqual(got, tt.added) {
+			t.Errorf("%s: added = %v, want %v", tt.name, got, tt.added)
+		}
+		if got := ids(removed); !reflect.DeepEqual(got, tt.removed) {
+			t.Errorf("%s: removed = %v, want %v", tt.name, got, tt.removed)
+		}
+		if got := rowIDs(distChanged); !reflect.DeepEqual(got, tt.distChanged) {
+			t.Errorf("%s: distChanged = %v, want %v", tt.name, got, tt.distChanged)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RecordError is the failure of one record in a batch operation. Index is
+// the record's position in the batch and Name its name, when it has one.
+type RecordError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e RecordError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("record %d (%s): %v", e.Index, e.Name, e.Err)
+	}
+	return fmt.Sprintf("record %d: %v", e.Index, e.Err)
+}
+
+func (e RecordError) Unwrap() error { return e.Err }
+
+// Errors collects every record that failed in a batch operation, which keeps
+// going past a failed record. It is an error on its own, and errors.Is and
+// errors.As look through it to the record errors, so a BoundsViolation is
+// still found in it. Return it only when Any is true: an empty Errors in an
+// error interface is not nil.
+type Errors []RecordError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i := range e {
+		errs[i] = e[i]
+	}
+	return errs
+}
+
+// Any reports whether any record failed.
+func (e Errors) Any() bool { return len(e) > 0 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// exportDoc is a document as `rethinkdb export --format ndjson` writes it.
+// Geometry and times keep the wire form the server uses, a $reql_type$
+// GEOMETRY object holding the GeoJSON type and coordinates and a $reql_type$
+// TIME object holding seconds since the epoch and a timezone.
+type exportDoc struct {
+	ID        string          `json:"id"`
+	Code      string          `json:"code,omitempty"`
+	Name      string          `json:"name"`
+	Region    string          `json:"region"`
+	Category  string          `json:"category"`
+	Layer     string          `json:"layer,omitempty"`
+	Deleted   bool            `json:"deleted"`
+	Priority  float64         `json:"priority"`
+	Elevation float64         `json:"elevation,omitempty"`
+	Area      *exportGeometry `json:"area"`
+	CreatedAt *exportTime     `json:"created_at,omitempty"`
+	UpdatedAt *exportTime     `json:"updated_at,omitempty"`
+}
+
+type exportGeometry struct {
+	ReqlType    string    `json:"$reql_type$"`
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+type exportTime struct {
+	ReqlType  string  `json:"$reql_type$"`
+	EpochTime float64 `json:"epoch_time"`
+	Timezone  string  `json:"timezone"`
+}
+
+func toExportTime(t *time.Time) *exportTime {
+	if t == nil {
+		return nil
+	}
+	return &exportTime{ReqlType: "TIME", EpochTime: float64(t.UnixNano()) / 1e9, Timezone: t.Format("-07:00")}
+}
+
+// time returns t as a time.Time in its timezone, or nil for a missing or
+// malformed time. RethinkDB keeps times to the millisecond, so that is all
+// that is kept of EpochTime.
+func (t *exportTime) time() *time.Time {
+	if t == nil || t.ReqlType != "TIME" {
+		return nil
+	}
+	loc := time.UTC
+	if zone, err := time.Parse("-07:00", t.Timezone); err == nil {
+		_, offset := zone.Zone()
+		loc = time.FixedZone(t.Timezone, offset)
+	}
+	sec, frac := math.Modf(t.EpochTime)
+	tm := time.Unix(int64(sec), int64(math.Round(frac*1e9/1e6))*1e6).In(loc)
+	return &tm
+}
+
+// loadFromExport reads the records of an NDJSON export file, one document
+// per line, keeping their original ids. Lines that aren't a record with a
+// point area, such as polygon regions, are skipped.
+func loadFromExport(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		// The area's type is read first: other geometries' coordinates
+		// are nested arrays that don't fit exportGeometry.
+		var kind struct {
+			Area *struct {
+				ReqlType string `json:"$reql_type$"`
+				Type     string `json:"type"`
+			} `json:"area"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &kind); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if kind.Area == nil || kind.Area.ReqlType != "GEOMETRY" || kind.Area.Type != "Point" {
+			continue
+		}
+		var doc exportDoc
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if len(doc.Area.Coordinates) != 2 {
+			continue
+		}
+		recs = append(recs, Record{
+			ID:         doc.ID,
+			Code:       doc.Code,
+			Name:       doc.Name,
+			GeoSpatial: types.Point{Lon: doc.Area.Coordinates[0], Lat: doc.Area.Coordinates[1]},
+			Region:     doc.Region,
+			Category:   doc.Category,
+			Layer:      doc.Layer,
+			Deleted:    doc.Deleted,
+			Priority:   doc.Priority,
+			Elevation:  doc.Elevation,
+			CreatedAt:  doc.CreatedAt.time(),
+			UpdatedAt:  doc.UpdatedAt.time(),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// exportAll writes every record of the table to w as NDJSON in the exportDoc
+// form, so loadFromExport can read the output back. Documents whose area
+// isn't a point, such as polygon regions, are skipped, as loadFromExport
+// would skip them. The table is split into
+// parallelism primary key ranges with Between and the ranges are read
+// concurrently. Lines from different ranges are interleaved in no particular
+// order, but each line is written with a single Write under a lock so no line
+// is ever split.
+//
+// The split points are evenly spaced hex prefixes, which divide the table
+// evenly when ids are the UUIDs RethinkDB generates. Other keys, such as
+// -primary-key code, still end up in exactly one range each since the first
+// and last ranges are open to r.MinVal and r.MaxVal, but the ranges will be
+// lopsided.
+//
+// Geometry is written normalized, with longitudes wrapped into [-180, 180),
+// so a point stored at longitude 180 exports the same as one at -180.
+func exportAll(session *r.Session, w io.Writer, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	bounds := keySplits(parallelism)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for i := 0; i+1 < len(bounds); i++ {
+		wg.Add(1)
+		go func(lower, upper interface{}) {
+			defer wg.Done()
+			res, err := runQuery(session, r.Table(tableName).Between(lower, upper))
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer res.Close()
+			var raw interface{}
+			for res.Next(&raw) {
+				var rec Record
+				err := decodeRow(raw, &rec)
+				raw = nil
+				if errors.Is(err, ErrGeometryTypeMismatch) {
+					continue
+				}
+				if err != nil {
+					fail(err)
+					return
+				}
+				line, err := json.Marshal(toExportDoc(rec))
+				if err != nil {
+					fail(err)
+					return
+				}
+				mu.Lock()
+				_, err = w.Write(append(line, '\n'))
+				mu.Unlock()
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+			if err := res.Err(); err != nil {
+				fail(err)
+			}
+		}(bounds[i], bounds[i+1])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// keySplits returns n+1 Between bounds from r.MinVal to r.MaxVal with n-1
+// evenly spaced hex strings between them.
+func keySplits(n int) []interface{} {
+	digits := 1
+	for space := 16; space < n; space *= 16 {
+		digits++
+	}
+	space := math.Pow(16, float64(digits))
+	bounds := []interface{}{r.MinVal}
+	for i := 1; i < n; i++ {
+		bounds = append(bounds, fmt.Sprintf("%0*x", digits, int(float64(i)*space/float64(n))))
+	}
+	return append(bounds, r.MaxVal)
+}
+
+func toExportDoc(rec Record) exportDoc {
+	p := rec.GeoSpatial
+	p.Lon = math.Mod(p.Lon+180, 360)
+	if p.Lon < 0 {
+		p.Lon += 360
+	}
+	p.Lon -= 180
+	return exportDoc{
+		ID:        rec.ID,
+		Code:      rec.Code,
+		Name:      rec.Name,
+		Region:    rec.Region,
+		Category:  rec.Category,
+		Layer:     rec.Layer,
+		Deleted:   rec.Deleted,
+		Priority:  rec.Priority,
+		Elevation: rec.Elevation,
+		Area: &exportGeometry{
+			ReqlType:    "GEOMETRY",
+			Type:        "Point",
+			Coordinates: []float64{p.Lon, p.Lat},
+		},
+		CreatedAt: toExportTime(rec.CreatedAt),
+		UpdatedAt: toExportTime(rec.UpdatedAt),
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 250e6, time.FixedZone("+02:00", 2*3600))
+	updated := created.Add(90 * time.Minute)
+	rec := Record{
+		ID:         "52d34203-585c-48d0-bf57-8adcbe2f2e9b",
+		Code:       "sf-1",
+		Name:       "first",
+		GeoSpatial: types.Point{Lon: -122.423246, Lat: 37.77929790366427},
+		Region:     "mission",
+		Category:   "cafe",
+		Layer:      "restaurants",
+		Priority:   2,
+		Elevation:  16.5,
+		CreatedAt:  &created,
+		UpdatedAt:  &updated,
+	}
+	line, err := json.Marshal(toExportDoc(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := `{"id": "r1", "name": "mission", "area": {"$reql_type$": "GEOMETRY", "type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}`
+	path := filepath.Join(t.TempDir(), "export.ndjson")
+	if err := os.WriteFile(path, []byte(string(line)+"\n"+region+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := loadFromExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("loaded %d records, want the point record only", len(recs))
+	}
+	got := recs[0]
+	if !got.CreatedAt.Equal(created) || !got.UpdatedAt.Equal(updated) {
+		t.Errorf("timestamps %v, %v, want %v, %v", got.CreatedAt, got.UpdatedAt, created, updated)
+	}
+	if got.CreatedAt.Format("-07:00") != "+02:00" {
+		t.Errorf("created_at in zone %s, want +02:00", got.CreatedAt.Format("-07:00"))
+	}
+	got.CreatedAt, got.UpdatedAt, rec.CreatedAt, rec.UpdatedAt = nil, nil, nil, nil
+	if !reflect.DeepEqual(got, rec) {
+		t.Errorf("got %+v, want %+v", got, rec)
+	}
+}
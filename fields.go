@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var renameFields = flag.String("rename-fields", "", `rename keys of JSON results, for example "Dist=distanceMeters,Name=title"; a key is renamed wherever it appears`)
+
+// fieldRenames is -rename-fields parsed, set by main.
+var fieldRenames map[string]string
+
+// OutputRecord is how a Record is written as JSON. Its json tags are the
+// wire format of results and are independent of the gorethink tags on
+// Record, which are the storage schema; the defaults are the Go field names
+// results have always been printed with. -rename-fields changes them at run
+// time.
+type OutputRecord struct {
+	ID         string      `json:"ID"`
+	Code       string      `json:"Code"`
+	Name       string      `json:"Name"`
+	GeoSpatial types.Point `json:"GeoSpatial"`
+	Region     string      `json:"Region"`
+	Category   string      `json:"Category"`
+	Layer      string      `json:"Layer"`
+	Deleted    bool        `json:"Deleted"`
+	Priority   float64     `json:"Priority"`
+	Elevation  float64     `json:"Elevation"`
+	CreatedAt  *time.Time  `json:"CreatedAt"`
+	UpdatedAt  *time.Time  `json:"UpdatedAt"`
+}
+
+// OutputResult is how a RecordWithDistance is written as JSON, see
+// OutputRecord.
+type OutputResult struct {
+	Dist      float64       `json:"Dist"`
+	Doc       *OutputRecord `json:"Doc"`
+	Geohash   string        `json:"Geohash,omitempty"`
+	VeryClose bool          `json:"VeryClose,omitempty"`
+	Rank      int           `json:"Rank,omitempty"`
+	*MultiUnitDist
+}
+
+func toOutputRecord(rec *Record) *OutputRecord {
+	if rec == nil {
+		return nil
+	}
+	return &OutputRecord{
+		ID:         rec.ID,
+		Code:       rec.Code,
+		Name:       rec.Name,
+		GeoSpatial: rec.GeoSpatial,
+		Region:     rec.Region,
+		Category:   rec.Category,
+		Layer:      rec.Layer,
+		Deleted:    rec.Deleted,
+		Priority:   rec.Priority,
+		Elevation:  rec.Elevation,
+		CreatedAt:  rec.CreatedAt,
+		UpdatedAt:  rec.UpdatedAt,
+	}
+}
+
+// MarshalJSON writes rec as an OutputRecord.
+func (rec Record) MarshalJSON() ([]byte, error) {
+	return marshalRenamed(toOutputRecord(&rec))
+}
+
+// MarshalJSON writes row as an OutputResult, with the distance rounded by
+// roundDist, so everything serialized to JSON, printed or served, is rounded
+// the same way.
+func (row RecordWithDistance) MarshalJSON() ([]byte, error) {
+	out := OutputResult{
+		Dist:      roundDist(row.Dist),
+		Doc:       toOutputRecord(row.Doc),
+		Geohash:   row.Geohash,
+		VeryClose: row.VeryClose,
+		Rank:      row.Rank,
+	}
+	if row.Units != nil {
+		out.MultiUnitDist = row.Units.rounded()
+	}
+	return marshalRenamed(out)
+}
+
+// MarshalJSON writes f with -rename-fields applied.
+func (f FlatResult) MarshalJSON() ([]byte, error) {
+	type plain FlatResult
+	return marshalRenamed(plain(f))
+}
+
+// parseFieldRenames parses "from=to,from=to" into a map.
+func parseFieldRenames(s string) (map[string]string, error) {
+	renames := map[string]string{}
+	if s == "" {
+		return renames, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid field rename %q, want from=to", pair)
+		}
+		renames[from] = to
+	}
+	return renames, nil
+}
+
+// marshalRenamed encodes v and renames its keys by fieldRenames. Without
+// renames the encoding is untouched; with them the objects are rebuilt, which
+// sorts their keys.
+func marshalRenamed(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || len(fieldRenames) == 0 {
+		return b, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameKeys(tree))
+}
+
+func renameKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if to, ok := fieldRenames[k]; ok {
+				k = to
+			}
+			out[k] = renameKeys(val)
+		}
+		return out
+	case []interface{}:
+		for i := range v {
+			v[i] = renameKeys(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// earthRadius is the mean Earth radius in meters. RethinkDB measures on the
+// WGS84 ellipsoid, so haversine distances can differ from its dist values by
+// up to about half a percent.
+const earthRadius = 6371008.8
+
+// haversine returns the great-circle distance between a and b in meters.
+func haversine(a, b types.Point) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := (b.Lat - a.Lat) * math.Pi / 180
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// unitMeters is the length in meters of each distance unit RethinkDB
+// accepts.
+var unitMeters = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.344,
+	"nm": 1852,
+	"ft": 0.3048,
+}
+
+// convertDistance converts d from one of the units in unitMeters to another.
+func convertDistance(d float64, from, to string) (float64, error) {
+	fromMeters, ok := unitMeters[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	toMeters, ok := unitMeters[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	return d * fromMeters / toMeters, nil
+}
+
+// geometryEqual compares two geometries coordinate by coordinate, allowing
+// each value to differ by up to epsilon. Floats coming back from RethinkDB
+// can drift slightly, so == is not good enough for lines and polygons.
+func geometryEqual(a, b types.Geometry, epsilon float64) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case "Point":
+		return pointEqual(a.Point, b.Point, epsilon)
+	case "LineString":
+		return lineEqual(a.Line, b.Line, epsilon)
+	case "Polygon":
+		if len(a.Lines) != len(b.Lines) {
+			return false
+		}
+		for i := range a.Lines {
+			if !lineEqual(a.Lines[i], b.Lines[i], epsilon) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func pointEqual(a, b types.Point, epsilon float64) bool {
+	return math.Abs(a.Lon-b.Lon) <= epsilon && math.Abs(a.Lat-b.Lat) <= epsilon
+}
+
+func lineEqual(a, b types.Line, epsilon float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !pointEqual(a[i], b[i], epsilon) {
+			return false
+		}
+	}
+	return true
+}
+
+// looksSwapped reports whether p looks like it was entered lat-first, which
+// it assumes when the latitude is beyond ±90. It is only a heuristic: points
+// where both values are within ±90 can't be told apart and are never flagged,
+// so callers should use it for warnings, not to reject points.
+func looksSwapped(p types.Point) bool {
+	return math.Abs(p.Lat) > 90
+}
+
+// validPoint reports whether p is a coordinate RethinkDB accepts: finite,
+// with the longitude within ±180 and the latitude within ±90.
+func validPoint(p types.Point) bool {
+	return !math.IsNaN(p.Lon) && !math.IsNaN(p.Lat) && math.Abs(p.Lon) <= 180 && math.Abs(p.Lat) <= 90
+}
+
+// Converters for Go geo libraries that use bare [2]float64 coordinates. The
+// names spell out the order because libraries disagree on it: GeoJSON and
+// most Go libraries are lon-first, many others are lat-first.
+
+// pointToLonLat returns p as [lon, lat].
+func pointToLonLat(p types.Point) [2]float64 {
+	return [2]float64{p.Lon, p.Lat}
+}
+
+// lonLatToPoint converts [lon, lat] to a point.
+func lonLatToPoint(c [2]float64) types.Point {
+	return types.Point{Lon: c[0], Lat: c[1]}
+}
+
+// pointToLatLon returns p as [lat, lon].
+func pointToLatLon(p types.Point) [2]float64 {
+	return [2]float64{p.Lat, p.Lon}
+}
+
+// latLonToPoint converts [lat, lon] to a point.
+func latLonToPoint(c [2]float64) types.Point {
+	return types.Point{Lon: c[1], Lat: c[0]}
+}
+
+// jitterPoint moves p by a random distance of up to maxMeters in a random
+// bearing, to blur exact locations. The distance is drawn so the new point is
+// uniform over the disc, and the longitude offset is scaled by the latitude
+// since meridians converge towards the poles.
+func jitterPoint(p types.Point, maxMeters float64, rng *rand.Rand) types.Point {
+	dist := maxMeters * math.Sqrt(rng.Float64())
+	bearing := 2 * math.Pi * rng.Float64()
+	dLat := dist * math.Cos(bearing) / earthRadius
+	dLon := dist * math.Sin(bearing) / (earthRadius * math.Cos(p.Lat*math.Pi/180))
+	return types.Point{
+		Lon: p.Lon + dLon*180/math.Pi,
+		Lat: p.Lat + dLat*180/math.Pi,
+	}
+}
+
+// roundPoint rounds both coordinates to the given number of decimals. Six
+// decimals is about 0.11 m at the equator, plenty for most uses; anything
+// past that only bloats documents and indexes.
+func roundPoint(p types.Point, decimals int) types.Point {
+	scale := math.Pow(10, float64(decimals))
+	return types.Point{
+		Lon: math.Round(p.Lon*scale) / scale,
+		Lat: math.Round(p.Lat*scale) / scale,
+	}
+}
+
+// snapPoint moves p to the nearest point of a grid with the given spacing in
+// degrees. It is roundPoint in units of grid instead of decimals.
+func snapPoint(p types.Point, grid float64) types.Point {
+	s := roundPoint(types.Point{Lon: p.Lon / grid, Lat: p.Lat / grid}, 0)
+	return types.Point{Lon: s.Lon * grid, Lat: s.Lat * grid}
+}
+
+// sphericalCentroid averages points on the sphere, each weighted equally:
+// they are turned into unit vectors, summed and the sum turned back into a
+// point. Unlike averaging lon and lat directly this is right across the
+// antimeridian, where the plain average of 179 and -179 would be 0, and near
+// the poles. Points spread evenly around the globe have no meaningful
+// centroid; the result is then arbitrary. points must not be empty.
+func sphericalCentroid(points []types.Point) types.Point {
+	var x, y, z float64
+	for _, p := range points {
+		lat, lon := p.Lat*math.Pi/180, p.Lon*math.Pi/180
+		x += math.Cos(lat) * math.Cos(lon)
+		y += math.Cos(lat) * math.Sin(lon)
+		z += math.Sin(lat)
+	}
+	return types.Point{
+		Lon: math.Atan2(y, x) * 180 / math.Pi,
+		Lat: math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi,
+	}
+}
+
+// crossesAntimeridian reports whether any edge of poly crosses ±180°
+// longitude. Edges are taken the short way round, as RethinkDB does, so an
+// edge whose ends are more than 180° of longitude apart crosses it. Such
+// polygons are easy to get wrong: the same coordinates meant as the long way
+// round give a very different shape, and the planar helpers here (convex
+// hull, bounds checks) treat them as the long way.
+//
+// GetNearest itself measures on the sphere and has no trouble with query
+// points near the antimeridian; only planar post-processing of the results
+// does.
+func crossesAntimeridian(poly types.Lines) bool {
+	for _, ring := range poly {
+		for i := 1; i < len(ring); i++ {
+			if math.Abs(ring[i].Lon-ring[i-1].Lon) > 180 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wgs84Radius is the WGS84 equatorial radius in meters, the sphere
+// polygonAreaMeters measures on.
+const wgs84Radius = 6378137
+
+// polygonAreaMeters returns the area of poly in square meters: the exterior
+// ring minus its holes, on a sphere of the WGS84 equatorial radius. Each ring
+// uses the spherical excess formula from Chamberlain and Duquette, "Some
+// algorithms for polygons on a sphere", which is what most web mapping
+// libraries use; it is within a fraction of a percent of the ellipsoidal
+// area for city-sized polygons.
+func polygonAreaMeters(poly types.Lines) float64 {
+	if len(poly) == 0 {
+		return 0
+	}
+	area := ringArea(poly[0])
+	for _, hole := range poly[1:] {
+		area -= ringArea(hole)
+	}
+	return area
+}
+
+func ringArea(ring types.Line) float64 {
+	n := len(ring)
+	if n < 3 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		p1, p2 := ring[i], ring[(i+1)%n]
+		sum += (p2.Lon - p1.Lon) * math.Pi / 180 * (2 + math.Sin(p1.Lat*math.Pi/180) + math.Sin(p2.Lat*math.Pi/180))
+	}
+	return math.Abs(sum * wgs84Radius * wgs84Radius / 2)
+}
+
+// polygonContains reports whether p is inside poly: inside the exterior ring
+// and outside every hole. It casts a ray on lon/lat treated as planar
+// coordinates, which matches RethinkDB for small polygons away from the
+// poles and the antimeridian; RethinkDB takes edges as geodesics, so for
+// long edges the two can disagree near the edge. Points exactly on an edge
+// may land on either side.
+func polygonContains(poly types.Lines, p types.Point) bool {
+	if len(poly) == 0 || !ringContains(poly[0], p) {
+		return false
+	}
+	for _, hole := range poly[1:] {
+		if ringContains(hole, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func ringContains(ring types.Line, p types.Point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+func TestGeometryEqual(t *testing.T) {
+	const eps = 1e-9
+	square := types.Lines{{{Lon: 0, Lat: 0}, {Lon: 1, Lat: 0}, {Lon: 1, Lat: 1}, {Lon: 0, Lat: 0}}}
+	drifted := types.Lines{{{Lon: 0, Lat: 0}, {Lon: 1 + eps/2, Lat: 0}, {Lon: 1, Lat: 1 - eps/2}, {Lon: 0, Lat: 0}}}
+	moved := types.Lines{{{Lon: 0, Lat: 0}, {Lon: 1 + 2*eps, Lat: 0}, {Lon: 1, Lat: 1}, {Lon: 0, Lat: 0}}}
+	line := types.Line{{Lon: 0, Lat: 0}, {Lon: 1, Lat: 1}}
+	tests := []struct {
+		name string
+		a, b types.Geometry
+		want bool
+	}{
+		{"same point", types.Geometry{Type: "Point", Point: types.Point{Lon: 1, Lat: 2}}, types.Geometry{Type: "Point", Point: types.Point{Lon: 1, Lat: 2}}, true},
+		{"point within epsilon", types.Geometry{Type: "Point", Point: types.Point{Lon: 1, Lat: 2}}, types.Geometry{Type: "Point", Point: types.Point{Lon: 1 + eps/2, Lat: 2 - eps/2}}, true},
+		{"point past epsilon", types.Geometry{Type: "Point", Point: types.Point{Lon: 1, Lat: 2}}, types.Geometry{Type: "Point", Point: types.Point{Lon: 1, Lat: 2 + 2*eps}}, false},
+		{"line within epsilon", types.Geometry{Type: "LineString", Line: line}, types.Geometry{Type: "LineString", Line: types.Line{{Lon: eps / 2, Lat: 0}, {Lon: 1, Lat: 1}}}, true},
+		{"line of another length", types.Geometry{Type: "LineString", Line: line}, types.Geometry{Type: "LineString", Line: line[:1]}, false},
+		{"polygon within epsilon", types.Geometry{Type: "Polygon", Lines: square}, types.Geometry{Type: "Polygon", Lines: drifted}, true},
+		{"polygon past epsilon", types.Geometry{Type: "Polygon", Lines: square}, types.Geometry{Type: "Polygon", Lines: moved}, false},
+		{"polygon with a hole", types.Geometry{Type: "Polygon", Lines: square}, types.Geometry{Type: "Polygon", Lines: append(square, square[0])}, false},
+		{"different types", types.Geometry{Type: "Point"}, types.Geometry{Type: "LineString"}, false},
+		{"unsupported type", types.Geometry{Type: "MultiPoint"}, types.Geometry{Type: "MultiPoint"}, false},
+	}
+	for _, tt := range tests {
+		if got := geometryEqual(tt.a, tt.b, eps); got != tt.want {
+			t.Errorf("%s: geometryEqual = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := geometryEqual(tt.b, tt.a, eps); got != tt.want {
+			t.Errorf("%s, swapped: geometryEqual = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCoordinateConverters(t *testing.T) {
+	// Berlin: the latitude and longitude differ enough that a swap shows.
+	p := types.Point{Lon: 13.405, Lat: 52.52}
+	if got, want := pointToLonLat(p), [2]float64{13.405, 52.52}; got != want {
+		t.Errorf("pointToLonLat = %v, want %v", got, want)
+	}
+	if got, want := pointToLatLon(p), [2]float64{52.52, 13.405}; got != want {
+		t.Errorf("pointToLatLon = %v, want %v", got, want)
+	}
+	if got := lonLatToPoint([2]float64{13.405, 52.52}); got != p {
+		t.Errorf("lonLatToPoint = %+v, want %+v", got, p)
+	}
+	if got := latLonToPoint([2]float64{52.52, 13.405}); got != p {
+		t.Errorf("latLonToPoint = %+v, want %+v", got, p)
+	}
+	if got := lonLatToPoint(pointToLonLat(p)); got != p {
+		t.Errorf("lon/lat round trip = %+v, want %+v", got, p)
+	}
+	if got := latLonToPoint(pointToLatLon(p)); got != p {
+		t.Errorf("lat/lon round trip = %+v, want %+v", got, p)
+	}
+}
+
+func TestPolygonAreaMeters(t *testing.T) {
+	// On the sphere a 1° by 1° cell from the equator covers
+	// R² · (π/180) · sin(1°), about 12,391 km².
+	cell := types.Line{{Lon: 0, Lat: 0}, {Lon: 1, Lat: 0}, {Lon: 1, Lat: 1}, {Lon: 0, Lat: 1}, {Lon: 0, Lat: 0}}
+	hole := types.Line{{Lon: 0, Lat: 0}, {Lon: 0.5, Lat: 0}, {Lon: 0.5, Lat: 0.5}, {Lon: 0, Lat: 0.5}, {Lon: 0, Lat: 0}}
+	tests := []struct {
+		name string
+		poly types.Lines
+		want float64
+	}{
+		{"1° cell", types.Lines{cell}, 12391399902.07},
+		{"1° cell with a 0.5° hole", types.Lines{cell, hole}, 9293431965.43},
+		{"no rings", nil, 0},
+	}
+	for _, tt := range tests {
+		got := polygonAreaMeters(tt.poly)
+		if math.Abs(got-tt.want) > 1e-6*tt.want {
+			t.Errorf("%s: polygonAreaMeters = %.2f m², want %.2f m²", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// Geofence is a polygon whose entries and exits are reported.
+type Geofence struct {
+	ID      string
+	Polygon types.Lines
+}
+
+// PositionUpdate is a new position of the record with the given id.
+type PositionUpdate struct {
+	ID    string
+	Point types.Point
+}
+
+// FenceEvent reports that a record entered or left a geofence.
+type FenceEvent struct {
+	FenceID  string      `json:"fence_id"`
+	RecordID string      `json:"record_id"`
+	Event    string      `json:"event"` // "enter" or "exit"
+	Point    types.Point `json:"point"`
+}
+
+// geofenceMonitor turns position updates into geofence entry and exit events.
+// It keeps, per fence, which records are inside it, and reports only changes.
+//
+// Membership is checked client-side with polygonContains rather than with a
+// GetIntersecting query per update: the fences are few and already in memory,
+// so each update costs no round trip. The price is polygonContains' planar
+// approximation, which can disagree with RethinkDB for points within a few
+// meters of a long fence edge, and a point moving along an edge can flap
+// between inside and outside.
+type geofenceMonitor struct {
+	fences []Geofence
+	inside map[string]map[string]bool // fence id -> record id -> inside
+}
+
+func newGeofenceMonitor(fences []Geofence) *geofenceMonitor {
+	m := &geofenceMonitor{fences: fences, inside: make(map[string]map[string]bool, len(fences))}
+	for _, fence := range fences {
+		m.inside[fence.ID] = map[string]bool{}
+	}
+	return m
+}
+
+// Update moves a record and returns the events it caused, in fence order. A
+// record's first update reports entries for the fences it starts in.
+func (m *geofenceMonitor) Update(u PositionUpdate) []FenceEvent {
+	var events []FenceEvent
+	for _, fence := range m.fences {
+		now := polygonContains(fence.Polygon, u.Point)
+		was := m.inside[fence.ID][u.ID]
+		if now == was {
+			continue
+		}
+		event := "exit"
+		if now {
+			event = "enter"
+			m.inside[fence.ID][u.ID] = true
+		} else {
+			delete(m.inside[fence.ID], u.ID)
+		}
+		events = append(events, FenceEvent{FenceID: fence.ID, RecordID: u.ID, Event: event, Point: u.Point})
+	}
+	return events
+}
+
+// watchGeofences reads position updates until the channel is closed and
+// sends the events they cause. The returned channel is closed after the last
+// event.
+func watchGeofences(updates <-chan PositionUpdate, fences []Geofence) <-chan FenceEvent {
+	events := make(chan FenceEvent)
+	go func() {
+		defer close(events)
+		m := newGeofenceMonitor(fences)
+		for u := range updates {
+			for _, ev := range m.Update(u) {
+				events <- ev
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var geohashPrecision = flag.Int("geohash", 0, "attach a geohash of this many characters to each nearest result, 0 attaches none")
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohashEncode returns the geohash of p with precision characters.
+// RethinkDB has no geohash function, so this is plain Go. Each character
+// carries five bits, alternating between longitude and latitude halvings and
+// starting with longitude; 9 characters is a cell of about 5m by 5m.
+func geohashEncode(p types.Point, precision int) string {
+	lon := [2]float64{-180, 180}
+	lat := [2]float64{-90, 90}
+	var sb strings.Builder
+	even := true
+	bits, ch := 0, 0
+	for sb.Len() < precision {
+		if even {
+			ch = ch<<1 | halve(&lon, p.Lon)
+		} else {
+			ch = ch<<1 | halve(&lat, p.Lat)
+		}
+		even = !even
+		if bits++; bits == 5 {
+			sb.WriteByte(geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+// halve narrows interval to the half holding v and returns 1 for the upper
+// half, 0 for the lower.
+func halve(interval *[2]float64, v float64) int {
+	mid := (interval[0] + interval[1]) / 2
+	if v >= mid {
+		interval[0] = mid
+		return 1
+	}
+	interval[1] = mid
+	return 0
+}
+
+// geohashDecode returns the center of the cell hash names. The true point
+// can be anywhere in that cell, so the result is only as precise as the hash.
+func geohashDecode(hash string) (types.Point, error) {
+	lon := [2]float64{-180, 180}
+	lat := [2]float64{-90, 90}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		v := strings.IndexByte(geohashAlphabet, hash[i])
+		if v < 0 {
+			return types.Point{}, fmt.Errorf("geohash %q: invalid character %q", hash, hash[i])
+		}
+		for bit := 4; bit >= 0; bit-- {
+			interval := &lat
+			if even {
+				interval = &lon
+			}
+			mid := (interval[0] + interval[1]) / 2
+			if v>>bit&1 == 1 {
+				interval[0] = mid
+			} else {
+				interval[1] = mid
+			}
+			even = !even
+		}
+	}
+	return types.Point{Lon: (lon[0] + lon[1]) / 2, Lat: (lat[0] + lat[1]) / 2}, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// geohashVectors are reference hashes from the geohash.org and Wikipedia
+// examples, and a point in San Francisco like the demo's, where both
+// coordinates are far from 0, at several precisions.
+var geohashVectors = []struct {
+	p    types.Point
+	hash string
+}{
+	{types.Point{Lon: -5.603, Lat: 42.605}, "ezs42"},
+	{types.Point{Lon: 10.40744, Lat: 57.64911}, "u4pruydqqvj"},
+	{types.Point{Lon: 0, Lat: 0}, "s0000"},
+	{types.Point{Lon: -122.423246, Lat: 37.779388}, "9"},
+	{types.Point{Lon: -122.423246, Lat: 37.779388}, "9q8y"},
+	{types.Point{Lon: -122.423246, Lat: 37.779388}, "9q8yyj"},
+	{types.Point{Lon: -122.423246, Lat: 37.779388}, "9q8yyjw0g"},
+	{types.Point{Lon: -122.423246, Lat: 37.779388}, "9q8yyjw0gs77"},
+}
+
+func TestGeohashEncode(t *testing.T) {
+	for _, v := range geohashVectors {
+		if got := geohashEncode(v.p, len(v.hash)); got != v.hash {
+			t.Errorf("geohashEncode(%+v, %d) = %q, want %q", v.p, len(v.hash), got, v.hash)
+		}
+	}
+}
+
+func TestGeohashDecode(t *testing.T) {
+	for _, v := range geohashVectors {
+		got, err := geohashDecode(v.hash)
+		if err != nil {
+			t.Fatalf("geohashDecode(%q): %v", v.hash, err)
+		}
+		// The center is at most half a cell from the encoded point. A cell
+		// of n characters spans 360° of longitude over 2^ceil(5n/2) and
+		// 180° of latitude over 2^floor(5n/2).
+		bits := 5 * len(v.hash)
+		lonErr := 180 / math.Exp2(float64((bits+1)/2))
+		latErr := 90 / math.Exp2(float64(bits/2))
+		if math.Abs(got.Lon-v.p.Lon) > lonErr || math.Abs(got.Lat-v.p.Lat) > latErr {
+			t.Errorf("geohashDecode(%q) = %+v, want within ±%g°, ±%g° of %+v", v.hash, got, lonErr, latErr, v.p)
+		}
+		if again := geohashEncode(got, len(v.hash)); again != v.hash {
+			t.Errorf("geohashEncode(geohashDecode(%q)) = %q", v.hash, again)
+		}
+	}
+	if _, err := geohashDecode("ezs4a"); err == nil {
+		t.Error(`geohashDecode("ezs4a") returned no error for the invalid "a"`)
+	}
+}
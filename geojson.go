@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// crs84 names WGS84 (EPSG:4326) with lon/lat axis order, which is what
+// RethinkDB and GeoJSON use.
+const crs84 = "urn:ogc:def:crs:OGC:1.3:CRS84"
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	CRS      *crs      `json:"crs,omitempty"`
+	Features []feature `json:"features"`
+}
+
+// crs is the named CRS member of the 2008 GeoJSON spec. RFC 7946 removed it
+// and fixes the CRS to WGS84, but some legacy consumers still require it.
+type crs struct {
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties"`
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func pointGeometry(p types.Point) geoJSONGeometry {
+	return geoJSONGeometry{Type: "Point", Coordinates: pointToLonLat(p)}
+}
+
+func polygonGeometry(poly types.Lines) geoJSONGeometry {
+	rings := make([][][2]float64, len(poly))
+	for i, ring := range poly {
+		rings[i] = make([][2]float64, len(ring))
+		for j, p := range ring {
+			rings[i][j] = pointToLonLat(p)
+		}
+	}
+	return geoJSONGeometry{Type: "Polygon", Coordinates: rings}
+}
+
+// recordsToGeoJSON encodes recs as a GeoJSON FeatureCollection. With withCRS
+// the collection carries a crs member naming WGS84; leave it off for RFC 7946
+// compliant output, it is only for legacy consumers that need it.
+func recordsToGeoJSON(recs []*Record, withCRS bool) ([]byte, error) {
+	return json.Marshal(recordsFeatureCollection(recs, withCRS))
+}
+
+// recordsWithHullToGeoJSON is recordsToGeoJSON with one more feature, named
+// "convex hull", holding the convex hull of the records when they have one.
+func recordsWithHullToGeoJSON(recs []*Record, withCRS bool) ([]byte, error) {
+	fc := recordsFeatureCollection(recs, withCRS)
+	if hull, ok := convexHull(recs); ok {
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			Geometry:   polygonGeometry(hull),
+			Properties: map[string]interface{}{"name": "convex hull"},
+		})
+	}
+	return json.Marshal(fc)
+}
+
+// resultsToGeoJSON is recordsToGeoJSON for nearest results: each feature's
+// properties carry the record's name, its distance from the query point and
+// the unit of that distance, for map tooltips. The geometry stays a plain
+// GeoJSON point and no crs member is written.
+func resultsToGeoJSON(recs []*RecordWithDistance, unit string) ([]byte, error) {
+	fc := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	for _, row := range recs {
+		fc.Features = append(fc.Features, feature{
+			Type:     "Feature",
+			ID:       row.Doc.ID,
+			Geometry: pointGeometry(row.Doc.GeoSpatial),
+			Properties: map[string]interface{}{
+				"name": row.Doc.Name,
+				"dist": roundDist(row.Dist),
+				"unit": unit,
+			},
+		})
+	}
+	return json.Marshal(fc)
+}
+
+func recordsFeatureCollection(recs []*Record, withCRS bool) featureCollection {
+	fc := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	if withCRS {
+		fc.CRS = &crs{Type: "name", Properties: map[string]string{"name": crs84}}
+	}
+	for _, rec := range recs {
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			ID:         rec.ID,
+			Geometry:   pointGeometry(rec.GeoSpatial),
+			Properties: map[string]interface{}{"name": rec.Name},
+		})
+	}
+	return fc
+}
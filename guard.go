@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var bounds = flag.String("bounds", "", `reject inserted records outside this box, "minLon,minLat,maxLon,maxLat"; empty accepts any valid point`)
+
+// BoundsViolation is a record boundsGuard rejected.
+type BoundsViolation struct {
+	Name   string
+	Point  types.Point
+	Reason string
+}
+
+func (v BoundsViolation) Error() string {
+	return fmt.Sprintf("record %q at lon %v, lat %v: %s", v.Name, v.Point.Lon, v.Point.Lat, v.Reason)
+}
+
+// boundsGuard returns a validator for insertOptions.Guard that rejects a
+// record with a BoundsViolation unless its point is a valid coordinate inside
+// region, a polygon in the types.Lines form described in hull.go. Containment
+// is checked with polygonContains, so the same planar caveats apply; keep the
+// region to something city sized.
+func boundsGuard(region types.Lines) func(Record) error {
+	return func(rec Record) error {
+		p := rec.GeoSpatial
+		switch {
+		case !validPoint(p):
+			return BoundsViolation{Name: rec.Name, Point: p, Reason: "not a valid coordinate"}
+		case !polygonContains(region, p):
+			return BoundsViolation{Name: rec.Name, Point: p, Reason: "outside the allowed region"}
+		}
+		return nil
+	}
+}
+
+// guardFromFlags returns the insert guard -bounds asks for, or nil without
+// one.
+func guardFromFlags() (func(Record) error, error) {
+	if *bounds == "" {
+		return nil, nil
+	}
+	region, err := parseBounds(*bounds)
+	if err != nil {
+		return nil, err
+	}
+	return boundsGuard(region), nil
+}
+
+// parseBounds parses "minLon,minLat,maxLon,maxLat" into a closed rectangle.
+func parseBounds(s string) (types.Lines, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bounds %q: want minLon,minLat,maxLon,maxLat", s)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bounds %q: %v", s, err)
+		}
+		v[i] = f
+	}
+	minLon, minLat, maxLon, maxLat := v[0], v[1], v[2], v[3]
+	if minLon >= maxLon || minLat >= maxLat {
+		return nil, fmt.Errorf("bounds %q: min must be below max", s)
+	}
+	return types.Lines{{
+		{Lon: minLon, Lat: minLat},
+		{Lon: maxLon, Lat: minLat},
+		{Lon: maxLon, Lat: maxLat},
+		{Lon: minLon, Lat: maxLat},
+		{Lon: minLon, Lat: minLat},
+	}}, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// Polygons are passed around as types.Lines the way types.Geometry stores
+// them: the first ring is the exterior, any further rings are holes, and
+// every ring is closed (its last point repeats the first).
+
+// convexHull returns the convex hull of the records' points as a polygon with
+// a single closed, counter-clockwise ring, using Andrew's monotone chain on
+// lon/lat treated as planar coordinates. That is fine for the small extents
+// nearest results cover but not across the antimeridian. ok is false when
+// there are fewer than 3 distinct points or they are all on one line.
+func convexHull(recs []*Record) (hull types.Lines, ok bool) {
+	pts := make([]types.Point, 0, len(recs))
+	seen := make(map[types.Point]bool, len(recs))
+	for _, rec := range recs {
+		if !seen[rec.GeoSpatial] {
+			seen[rec.GeoSpatial] = true
+			pts = append(pts, rec.GeoSpatial)
+		}
+	}
+	if len(pts) < 3 {
+		return nil, false
+	}
+	sort.Slice(pts, func(i, j int) bool {
+		if pts[i].Lon != pts[j].Lon {
+			return pts[i].Lon < pts[j].Lon
+		}
+		return pts[i].Lat < pts[j].Lat
+	})
+
+	ring := make(types.Line, 0, 2*len(pts))
+	// lower hull
+	for _, p := range pts {
+		for len(ring) >= 2 && cross(ring[len(ring)-2], ring[len(ring)-1], p) <= 0 {
+			ring = ring[:len(ring)-1]
+		}
+		ring = append(ring, p)
+	}
+	// upper hull
+	lower := len(ring) + 1
+	for i := len(pts) - 2; i >= 0; i-- {
+		p := pts[i]
+		for len(ring) >= lower && cross(ring[len(ring)-2], ring[len(ring)-1], p) <= 0 {
+			ring = ring[:len(ring)-1]
+		}
+		ring = append(ring, p)
+	}
+	// ring now ends with pts[0] again, which closes it
+	if len(ring) < 4 {
+		return nil, false
+	}
+	return types.Lines{ring}, true
+}
+
+// cross is the z component of (a-o) x (b-o): positive when o, a, b turn
+// counter-clockwise.
+func cross(o, a, b types.Point) float64 {
+	return (a.Lon-o.Lon)*(b.Lat-o.Lat) - (a.Lat-o.Lat)*(b.Lon-o.Lon)
+}
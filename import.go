@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// importFeature is one feature of a GeoJSON FeatureCollection being imported.
+type importFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// readGeoJSONFeatures reads the features of a GeoJSON FeatureCollection file.
+func readGeoJSONFeatures(path string) ([]importFeature, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc struct {
+		Type     string          `json:"type"`
+		Features []importFeature `json:"features"`
+	}
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("%s: not a FeatureCollection", path)
+	}
+	return fc.Features, nil
+}
+
+// featurePoint returns the point of a Point feature, or why the feature
+// can't be imported. It is the validation both validateImport and the import
+// itself run, so a file that validates imports.
+func featurePoint(f importFeature) (types.Point, error) {
+	if f.Type != "Feature" {
+		return types.Point{}, fmt.Errorf("type is %q, not Feature", f.Type)
+	}
+	if f.Geometry.Type != "Point" {
+		return types.Point{}, fmt.Errorf("geometry is a %q, only Point is supported", f.Geometry.Type)
+	}
+	var c []float64
+	if err := json.Unmarshal(f.Geometry.Coordinates, &c); err != nil {
+		return types.Point{}, fmt.Errorf("bad coordinates: %v", err)
+	}
+	// A third position element is an altitude, which GeoJSON allows and
+	// the geo index ignores.
+	if len(c) != 2 && len(c) != 3 {
+		return types.Point{}, fmt.Errorf("point has %d coordinates, want lon, lat", len(c))
+	}
+	p := types.Point{Lon: c[0], Lat: c[1]}
+	if !validPoint(p) {
+		if looksSwapped(p) {
+			return p, fmt.Errorf("lon %v, lat %v is out of range, lon and lat look swapped", p.Lon, p.Lat)
+		}
+		return p, fmt.Errorf("lon %v, lat %v is out of range", p.Lon, p.Lat)
+	}
+	return p, nil
+}
+
+// ImportProblem is a feature of an import file that can't be imported.
+// Feature is its index in the file's features array.
+type ImportProblem struct {
+	Feature int
+	Name    string
+	Problem string
+}
+
+func (p ImportProblem) String() string {
+	if p.Name != "" {
+		return fmt.Sprintf("feature %d (%s): %s", p.Feature, p.Name, p.Problem)
+	}
+	return fmt.Sprintf("feature %d: %s", p.Feature, p.Problem)
+}
+
+// ImportReport is the outcome of validateImport.
+type ImportReport struct {
+	Valid    int
+	Invalid  int
+	Problems []ImportProblem
+}
+
+// validateImport checks a GeoJSON import file without touching the
+// database, running the same per-feature validation as upsertFromGeoJSON.
+// Invalid features are counted and listed in the report; the error is only
+// for a file that can't be read or parsed at all.
+func validateImport(path string) (ImportReport, error) {
+	var report ImportReport
+	features, err := readGeoJSONFeatures(path)
+	if err != nil {
+		return report, err
+	}
+	for i, f := range features {
+		if _, err := featurePoint(f); err != nil {
+			name, _ := f.Properties["name"].(string)
+			report.Invalid++
+			report.Problems = append(report.Problems, ImportProblem{Feature: i, Name: name, Problem: err.Error()})
+			continue
+		}
+		report.Valid++
+	}
+	return report, nil
+}
+
+// upsertFromGeoJSON loads the Point features of a GeoJSON file into the table,
+// matching them to existing records on the keyProp property rather than on
+// the primary key, so reloading an updated file updates records in place
+// instead of duplicating them. Each feature's properties become fields of
+// the document and its point the area.
+//
+// Insert's Conflict option only ever looks at the primary key, so the
+// existing primary keys are looked up through a keyProp secondary index
+// first (it is created if missing) and set on the matching documents; the
+// insert with Conflict "update" then merges those into the stored records.
+// When the file has several features with the same key, the last one wins.
+// Documents that match but don't change count as neither inserted nor
+// updated. A file with invalid features is not loaded at all; the error
+// wraps an Errors listing every one of them, indexed by their position in
+// the file.
+func upsertFromGeoJSON(session *r.Session, path string, keyProp string) (inserted, updated int, err error) {
+	features, err := readGeoJSONFeatures(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var keys []interface{}
+	var errs Errors
+	docs := map[interface{}]map[string]interface{}{}
+	for i, f := range features {
+		name, _ := f.Properties["name"].(string)
+		p, err := featurePoint(f)
+		if err != nil {
+			errs = append(errs, RecordError{Index: i, Name: name, Err: err})
+			continue
+		}
+		key := f.Properties[keyProp]
+		switch key.(type) {
+		case string, float64, bool:
+		default:
+			errs = append(errs, RecordError{Index: i, Name: name, Err: fmt.Errorf("%q property missing or not a string, number or bool", keyProp)})
+			continue
+		}
+		doc := map[string]interface{}{}
+		for k, v := range f.Properties {
+			doc[k] = v
+		}
+		doc[indexName] = p
+		if _, seen := docs[key]; !seen {
+			keys = append(keys, key)
+		}
+		docs[key] = doc
+	}
+	if errs.Any() {
+		return 0, 0, fmt.Errorf("%s: %w", path, errs)
+	}
+	if len(keys) == 0 {
+		return 0, 0, nil
+	}
+
+	if err := ensureIndex(session, tableName, keyProp); err != nil {
+		return 0, 0, fmt.Errorf("cannot create index: %v", err)
+	}
+	if err := r.Table(tableName).IndexWait(keyProp).Exec(session); err != nil {
+		return 0, 0, err
+	}
+
+	var existing []map[string]interface{}
+	pk := *primaryKey
+	res, err := runQuery(session, r.Table(tableName).GetAllByIndex(keyProp, r.Args(keys)).Pluck(pk, keyProp))
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = res.All(&existing); err != nil {
+		return 0, 0, err
+	}
+	matched := map[interface{}]bool{}
+	for _, row := range existing {
+		if doc, ok := docs[row[keyProp]]; ok {
+			doc[pk] = row[pk]
+			matched[row[keyProp]] = true
+		}
+	}
+
+	batch := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		doc := docs[key]
+		if matched[key] {
+			doc["updated_at"] = r.Now()
+		} else {
+			doc["created_at"] = r.Now()
+		}
+		batch = append(batch, doc)
+	}
+	resp, err := r.Table(tableName).Insert(batch, r.InsertOpts{Conflict: "update"}).RunWrite(session)
+	stats.observeInsert(resp.Inserted, err)
+	// The server generates keys, in batch order, for the new documents,
+	// the ones without a primary key.
+	generated := resp.GeneratedKeys
+	for _, doc := range batch {
+		var id string
+		if key, ok := doc[pk]; ok {
+			id = fmt.Sprint(key)
+		} else if len(generated) > 0 {
+			id, generated = generated[0], generated[1:]
+		}
+		logWrite("upsert", id, err)
+	}
+	return resp.Inserted, resp.Replaced, err
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+var (
+	confirm   = flag.Bool("confirm", false, "confirm destructive commands such as migrate-index")
+	waitIndex = flag.Bool("wait", true, "wait for a recreated index to finish building")
+
+	indexWaitTimeout = flag.Duration("index-wait", 0, "when a query hits an index that is still building, wait up to this long for it and retry, 0 fails right away")
+)
+
+// ErrIndexBuilding is what an IndexBuildingError matches with errors.Is.
+var ErrIndexBuilding = errors.New("index is still building")
+
+// IndexBuildingError is returned when a query needs an index whose
+// construction hasn't finished, typically right after createTable or
+// migrate-index. Progress is the fraction built, from IndexStatus, so callers
+// can decide how long to back off before retrying.
+type IndexBuildingError struct {
+	Table    string
+	Index    string
+	Progress float64
+}
+
+func (e *IndexBuildingError) Error() string {
+	return fmt.Sprintf("index %q on %s is still building (%.0f%% done), retry later", e.Index, e.Table, e.Progress*100)
+}
+
+func (e *IndexBuildingError) Unwrap() error { return ErrIndexBuilding }
+
+// ErrNoGeoIndex is returned by checkGeoIndex, wrapped with the index and
+// table, for an index that doesn't exist or isn't a geo index.
+var ErrNoGeoIndex = errors.New("no such geo index")
+
+// knownGeoIndexes maps the "table/index" pairs checkGeoIndex found to when
+// it found them, so only a query every geoIndexCheckTTL on an index pays for
+// the check. An index dropped or recreated under another name is noticed
+// within that time; until then queries on it fail in the server instead.
+var knownGeoIndexes sync.Map
+
+const geoIndexCheckTTL = time.Minute
+
+// checkGeoIndex makes sure index exists on table, through IndexList, and is
+// a geo index that GetNearest can use.
+func checkGeoIndex(session *r.Session, table, index string) error {
+	key := table + "/" + index
+	if checked, ok := knownGeoIndexes.Load(key); ok && time.Since(checked.(time.Time)) < geoIndexCheckTTL {
+		return nil
+	}
+	exists, err := hasIndex(session, table, index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s has no index %q", ErrNoGeoIndex, table, index)
+	}
+	status, err := indexStatus(session, table, index)
+	if err != nil {
+		return err
+	}
+	if !status.Geo {
+		return fmt.Errorf("%w: index %q on %s is not a geo index", ErrNoGeoIndex, index, table)
+	}
+	knownGeoIndexes.Store(key, time.Now())
+	return nil
+}
+
+// isIndexNotReady reports whether err is the server refusing a query because
+// an index it uses is still building. The driver has no error type for it,
+// only the message.
+func isIndexNotReady(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "was accessed before its construction was finished")
+}
+
+// awaitIndex is called after a query failed with isIndexNotReady. With
+// -index-wait it blocks on IndexWait for up to that long and returns nil once
+// the index is ready, so the caller can retry. Otherwise, or if the index is
+// still not ready by then, it returns an IndexBuildingError.
+func awaitIndex(session *r.Session, table, index string) error {
+	if *indexWaitTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *indexWaitTimeout)
+		defer cancel()
+		res, err := runQuery(session, r.DB(DBName).Table(table).IndexWait(index), r.RunOpts{Context: ctx})
+		if err == nil {
+			return res.Close()
+		}
+	}
+	status, err := indexStatus(session, table, index)
+	if err != nil {
+		return err
+	}
+	if status.Ready {
+		return nil
+	}
+	return &IndexBuildingError{Table: table, Index: index, Progress: status.Progress}
+}
+
+// IndexStatus is one row of IndexStatus output.
+type IndexStatus struct {
+	Index    string  `gorethink:"index"`
+	Ready    bool    `gorethink:"ready"`
+	Geo      bool    `gorethink:"geo"`
+	Multi    bool    `gorethink:"multi"`
+	Progress float64 `gorethink:"progress"`
+}
+
+func indexStatus(session *r.Session, table, name string) (*IndexStatus, error) {
+	var status IndexStatus
+	res, err := runQuery(session, r.DB(DBName).Table(table).IndexStatus(name))
+	if err != nil {
+		return nil, err
+	}
+	if err = res.One(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// migrateIndex recreates the area index as a geo index if it isn't one, which
+// GetNearest requires. Dropping a secondary index doesn't touch documents, but
+// documents whose area isn't a geometry would silently fall out of a geo
+// index, so the migration refuses to run while there are any. Nothing is
+// changed unless confirmed is true. Progress is written to out.
+func migrateIndex(session *r.Session, out io.Writer, confirmed, wait bool) error {
+	status, err := indexStatus(session, tableName, indexName)
+	if err != nil {
+		return err
+	}
+	if status.Geo {
+		fmt.Fprintf(out, "index %q is already a geo index\n", indexName)
+		return nil
+	}
+
+	var nonGeo int
+	res, err := runQuery(session, r.DB(DBName).Table(tableName).
+		Filter(r.Row.Field(indexName).TypeOf().Ne("PTYPE<GEOMETRY>")).
+		Count())
+	if err != nil {
+		return err
+	}
+	if err = res.One(&nonGeo); err != nil {
+		return err
+	}
+	if nonGeo > 0 {
+		return fmt.Errorf("%d documents have a non-geometry %q field and would be left out of a geo index, fix them first", nonGeo, indexName)
+	}
+	if !confirmed {
+		return errors.New("index " + indexName + " is not a geo index, rerun with -confirm to drop and recreate it")
+	}
+
+	fmt.Fprintf(out, "recreating index %q as a geo index\n", indexName)
+	if err := r.DB(DBName).Table(tableName).IndexDrop(indexName).Exec(session); err != nil {
+		return err
+	}
+	if err := r.DB(DBName).Table(tableName).IndexCreate(indexName, r.IndexCreateOpts{
+		Geo: true,
+	}).Exec(session); err != nil {
+		return err
+	}
+	if wait {
+		return r.DB(DBName).Table(tableName).IndexWait(indexName).Exec(session)
+	}
+	return nil
+}
+
+// createGeoIndex creates a geo index on the geometry at path, a dot separated
+// field path such as "area" or "location.area". A top-level field uses the
+// plain IndexCreate form; a nested one needs the function form, which here is
+// r.Row.Field("location").Field("area"). The index function must return a
+// geometry for every document that should be indexed; documents where it
+// errors (for example a missing field) are left out of the index.
+func createGeoIndex(session *r.Session, table, index, path string) error {
+	fields := strings.Split(path, ".")
+	t := r.DB(DBName).Table(table)
+	if len(fields) == 1 {
+		return t.IndexCreate(index, r.IndexCreateOpts{Geo: true}).Exec(session)
+	}
+	return t.IndexCreateFunc(index, fieldPath(r.Row, fields), r.IndexCreateOpts{Geo: true}).Exec(session)
+}
+
+// fieldPath selects the nested field fields[0].fields[1]... of term.
+func fieldPath(term r.Term, fields []string) r.Term {
+	for _, f := range fields {
+		term = term.Field(f)
+	}
+	return term
+}
+
+func hasIndex(session *r.Session, table, index string) (bool, error) {
+	var names []string
+	res, err := runQuery(session, r.DB(DBName).Table(table).IndexList())
+	if err != nil {
+		return false, err
+	}
+	if err = res.All(&names); err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if name == index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ensureGeoIndex creates the geo index like createGeoIndex unless it already
+// exists. An existing index that isn't a geo index is an error, since
+// GetNearest can't use it; migrate-index fixes that.
+func ensureGeoIndex(session *r.Session, table, index, path string) error {
+	exists, err := hasIndex(session, table, index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return createGeoIndex(session, table, index, path)
+	}
+	status, err := indexStatus(session, table, index)
+	if err != nil {
+		return err
+	}
+	if !status.Geo {
+		return fmt.Errorf("index %q on %s exists but is not a geo index, run the migrate-index command to recreate it", index, table)
+	}
+	return nil
+}
+
+// ensureGeoIndexFunc is ensureGeoIndex for a geo index built from fn, the
+// function form of IndexCreate, so the index can hold computed geometry
+// rather than a stored field. fn gets each document and must return a
+// geometry, built from the document alone: it has to be deterministic, so
+// no r.Now, r.JS or queries on other tables. Documents for which it returns
+// something else or errors are left out of the index, and GetNearest on the
+// index never finds them.
+func ensureGeoIndexFunc(session *r.Session, table, index string, fn func(r.Term) interface{}) error {
+	exists, err := hasIndex(session, table, index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return r.DB(DBName).Table(table).IndexCreateFunc(index, fn, r.IndexCreateOpts{Geo: true}).Exec(session)
+	}
+	status, err := indexStatus(session, table, index)
+	if err != nil {
+		return err
+	}
+	if !status.Geo {
+		return fmt.Errorf("index %q on %s exists but is not a geo index, run the migrate-index command to recreate it", index, table)
+	}
+	return nil
+}
+
+// pointFromCoordinates is a geo index function that rebuilds the point of
+// area from its GeoJSON coordinates, the way an index is built on documents
+// that keep a bare [lon, lat] array instead of a geometry. For the sample
+// records it indexes the same points as the area field does, so every demo
+// query works with either index.
+func pointFromCoordinates(doc r.Term) interface{} {
+	coords := doc.Field(indexName).ToGeoJSON().Field("coordinates")
+	return r.Point(coords.Nth(0), coords.Nth(1))
+}
+
+// ensureIndex creates a plain secondary index on the field of the same name
+// unless it already exists.
+func ensureIndex(session *r.Session, table, index string) error {
+	exists, err := hasIndex(session, table, index)
+	if err != nil || exists {
+		return err
+	}
+	return r.DB(DBName).Table(table).IndexCreate(index).Exec(session)
+}
+
+// ensureIndexFunc is ensureIndex for an index built from fn instead of the
+// field of the same name.
+func ensureIndexFunc(session *r.Session, table, index string, fn func(r.Term) interface{}) error {
+	exists, err := hasIndex(session, table, index)
+	if err != nil || exists {
+		return err
+	}
+	return r.DB(DBName).Table(table).IndexCreateFunc(index, fn).Exec(session)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	maxQueries   = flag.Int("max-queries", 32, "serve: at most this many queries run at once, 0 is unlimited; keep it at or below the connection pool size")
+	queueTimeout = flag.Duration("queue-timeout", time.Second, "serve: how long a request waits for a free query slot before getting a 503")
+)
+
+// weightedSem is a counting semaphore whose acquirers take any number of
+// slots at once. Waiters aren't served in order: a release wakes them all and
+// whoever fits goes, so a large request can wait behind a stream of small
+// ones until its timeout.
+type weightedSem struct {
+	mu      sync.Mutex
+	size    int
+	used    int
+	changed chan struct{}
+}
+
+func newWeightedSem(size int) *weightedSem {
+	return &weightedSem{size: size, changed: make(chan struct{})}
+}
+
+// acquire takes n slots, n being clamped to the semaphore's size, and
+// returns how many it took. It blocks until they are free or ctx is done, in
+// which case it takes none and returns ctx's error.
+func (s *weightedSem) acquire(ctx context.Context, n int) (int, error) {
+	if n > s.size {
+		n = s.size
+	}
+	for {
+		s.mu.Lock()
+		if s.used+n <= s.size {
+			s.used += n
+			s.mu.Unlock()
+			return n, nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// release returns n slots taken by acquire.
+func (s *weightedSem) release(n int) {
+	s.mu.Lock()
+	s.used -= n
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// admit takes n query slots for req, fewer if -max-queries is smaller,
+// waiting at most -queue-timeout. It returns how many it took and a release
+// func the caller must call once its queries are done, typically deferred so
+// it also runs when a query fails. If the slots don't free up in time it
+// answers 503 Service Unavailable and returns false.
+func (s *server) admit(w http.ResponseWriter, req *http.Request, n int) (taken int, release func(), ok bool) {
+	if s.sem == nil {
+		return n, func() {}, true
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), *queueTimeout)
+	defer cancel()
+	taken, err := s.sem.acquire(ctx, n)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many concurrent queries, retry later", http.StatusServiceUnavailable)
+		return 0, nil, false
+	}
+	return taken, func() { s.sem.release(taken) }, true
+}
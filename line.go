@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// nearestToLine returns the records near a path, closest first, with each
+// record's distance to the path. GetNearest only takes a point, so the line
+// is sampled every interval meters along each segment (plus each vertex),
+// a nearest query on index with opts is run at every sample, and the rows
+// are merged, keeping each record once with its smallest distance.
+// opts.MaxResults, if set, cuts the merged rows.
+//
+// The distance is to the nearest sample, not to the line, so it overstates
+// the true distance: a record d from the line can be reported as far as
+// sqrt(d² + (interval/2)²). A smaller interval is more accurate but runs one
+// query per sample; once half the interval exceeds opts.MaxDist there are
+// gaps where records right next to the line are missed entirely. interval is
+// always in meters, whatever opts.Unit is. Samples are interpolated linearly
+// in lon/lat, which is fine for segments of a few kilometers.
+//
+// Records are deduplicated on id, so like nearestExcluding this needs the
+// default -primary-key.
+func nearestToLine(session *r.Session, index string, line types.Line, interval float64, opts r.GetNearestOpts, runOpts ...r.RunOpts) ([]*RecordWithDistance, error) {
+	if interval <= 0 {
+		return nil, errors.New("sampling interval must be positive")
+	}
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	maxResults, _ := opts.MaxResults.(int)
+	best := map[string]*RecordWithDistance{}
+	for _, p := range sampleLine(line, interval) {
+		rows, err := nearestInTable(session, tableName, p, opts, runOpts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if prev, ok := best[row.Doc.ID]; !ok || row.Dist < prev.Dist {
+				best[row.Doc.ID] = row
+			}
+		}
+	}
+
+	merged := make([]*RecordWithDistance, 0, len(best))
+	for _, row := range best {
+		merged = append(merged, row)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Dist != merged[j].Dist {
+			return merged[i].Dist < merged[j].Dist
+		}
+		return merged[i].Doc.ID < merged[j].Doc.ID
+	})
+	if maxResults > 0 && len(merged) > maxResults {
+		merged = merged[:maxResults]
+	}
+	setRanks(merged)
+	return merged, nil
+}
+
+// sampleLine returns the vertices of line and, between them, points at most
+// interval meters apart.
+func sampleLine(line types.Line, interval float64) []types.Point {
+	if len(line) == 0 {
+		return nil
+	}
+	samples := []types.Point{line[0]}
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		n := int(math.Ceil(haversine(a, b) / interval))
+		for k := 1; k <= n; k++ {
+			t := float64(k) / float64(n)
+			samples = append(samples, types.Point{
+				Lon: a.Lon + (b.Lon-a.Lon)*t,
+				Lat: a.Lat + (b.Lat-a.Lat)*t,
+			})
+		}
+	}
+	return samples
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
+	"sort"
 	"time"
 
 	r "gopkg.in/gorethink/gorethink.v3"
@@ -11,99 +17,395 @@ import (
 )
 
 type Record struct {
+	ID         string      `gorethink:"id,omitempty"`
+	Code       string      `gorethink:"code,omitempty"`
 	Name       string      `gorethink:"name"`
 	GeoSpatial types.Point `gorethink:"area"`
+	Region     string      `gorethink:"region,omitempty"`
+	Category   string      `gorethink:"category,omitempty"`
+	Layer      string      `gorethink:"layer,omitempty"`
+	Deleted    bool        `gorethink:"deleted,omitempty"`
+	Priority   float64     `gorethink:"priority,omitempty"`
+	Elevation  float64     `gorethink:"elevation,omitempty"` // meters, not part of the 2D geo index
+	CreatedAt  *time.Time  `gorethink:"created_at,omitempty"`
+	UpdatedAt  *time.Time  `gorethink:"updated_at,omitempty"`
 }
 
 type RecordWithDistance struct {
 	Dist float64 `gorethink:"dist"`
 	Doc  *Record `gorethink:"doc"`
+	// Geohash is filled in client-side with -geohash, see geohashEncode.
+	Geohash string `gorethink:"-" json:",omitempty"`
+	// VeryClose is computed by the server in nearestFlaggingClose.
+	VeryClose bool `gorethink:"very_close,omitempty"`
+	// Units is the distance in the units of -units, converted client-side.
+	Units *MultiUnitDist `gorethink:"-"`
+	// Rank is the row's 1-based position in its result, see setRanks.
+	Rank int `gorethink:"-"`
 }
 
+// DBName is the database all tables live in. A database in -dsn replaces it.
+var DBName = "test"
+
 const (
-	DBName    = "test"
 	tableName = "geospatial"
 	indexName = "area"
+
+	categoryIndex = "category"
+	nameIndex     = "name"
+	updatedIndex  = "updated_at"
+	layerIndex    = "layer"
 )
 
+// limit only trims what gets printed. MaxResults in GetNearestOpts is the
+// server-side cap on how many candidates RethinkDB computes and sends back;
+// limit is applied afterwards, on the client, to the already decoded rows.
+var limit = flag.Int("limit", 0, "print at most this many results per query, 0 prints all (client-side, does not change MaxResults)")
+
+var outPath = flag.String("out", "", "write query results to this file (created or truncated) instead of stdout")
+
+// primaryKey defaults to RethinkDB's own id. With "code" the records are keyed
+// on their natural code instead; nearestExcluding and the paging demo filter
+// on id and only work with the default.
+var primaryKey = flag.String("primary-key", "id", `primary key field of the table, "id" or a natural key such as "code"`)
+
+var geoIndexFromCoords = flag.Bool("geo-index-from-coordinates", false, "build the demo's area geo index with the function form, from the coordinates of area, see pointFromCoordinates")
+
+var dropTable = flag.Bool("drop", true, "drop and recreate the table in the demo; with -drop=false an existing table and its indexes are reused")
+
+// soft durability acknowledges a write once it is in memory, before it hits
+// disk. It makes seeding much faster but the write can be lost on a crash.
+var durability = flag.String("durability", "hard", `insert durability, "hard" or "soft"`)
+
+var insertRate = flag.Float64("insert-rate", 0, "insert at most this many records per second, 0 is unlimited")
+
+var jitter = flag.Float64("jitter", 0, "move each sample point by a random distance of up to this many meters before inserting it")
+
+var precision = flag.Int("precision", -1, "round coordinates to this many decimals before inserting, negative keeps them as they are (6 decimals is about 0.11m)")
+
+// results is where query results are printed; main points it at -out when set.
+var results io.Writer = os.Stdout
+
+// demoPoint is the point the demo queries search around, -near if it is set.
+var demoPoint = types.Point{Lon: -122.4153346282659, Lat: 37.77874812639591}
+
+var near = flag.String("near", "", `search around this point instead of the demo point, "lat,lon" or "geo:lat,lon" as people usually write it, latitude first`)
+
 var records = []Record{
 	{
+		Code:       "sf-1",
 		Name:       "first",
+		Category:   "cafe",
+		Layer:      "restaurants",
 		GeoSpatial: types.Point{Lon: -122.423246, Lat: 37.77929790366427},
 	}, {
+		Code:       "sf-2",
 		Name:       "second",
+		Category:   "bar",
+		Layer:      "hotels",
 		GeoSpatial: types.Point{Lon: -122.42326814543915, Lat: 37.77929963483801},
 	}, {
+		Code:       "sf-3",
 		Name:       "third",
+		Category:   "cafe",
+		Layer:      "restaurants",
 		GeoSpatial: types.Point{Lon: -122.4232894398445, Lat: 37.779304761831504},
 	}, {
+		Code:       "sf-4",
 		Name:       "fourth",
+		Category:   "museum",
+		Layer:      "hotels",
 		GeoSpatial: types.Point{Lon: -122.423246, Lat: 37.779478096334365},
 	}, {
+		Code:       "sf-5",
 		Name:       "fifth",
+		Category:   "bar",
+		Layer:      "restaurants",
 		GeoSpatial: types.Point{Lon: -124.423246, Lat: 37.779478096334365},
 	},
 }
 
 func main() {
-	session, err := r.Connect(r.ConnectOpts{
-		Address: "127.0.0.1",
-	})
+	flag.Parse()
+	if !validOutputFormat(*outputFormat) {
+		log.Fatalln("Unknown output format: ", *outputFormat)
+	}
+	if *durability != "hard" && *durability != "soft" {
+		log.Fatalln("Unknown durability: ", *durability)
+	}
+	var err error
+	if fieldRenames, err = parseFieldRenames(*renameFields); err != nil {
+		log.Fatalln(err)
+	}
+	if outputUnits, err = parseUnits(*distUnits); err != nil {
+		log.Fatalln(err)
+	}
+	if *near != "" {
+		if demoPoint, err = parseLatLon(*near); err != nil {
+			log.Fatalln("Invalid -near: ", err)
+		}
+	}
+
+	if *outPath != "" {
+		// Results are written straight to the file without buffering, so
+		// whatever was printed before a log.Fatal is already on disk.
+		f, err := os.Create(*outPath)
+		if err != nil {
+			log.Fatalln("Cannot create output file: ", err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Println("Cannot close output file: ", err)
+			}
+		}()
+		results = f
+	}
+
+	if *writeLogPath != "" {
+		if writeLog, err = openWriteLog(*writeLogPath); err != nil {
+			log.Fatalln("Cannot open write log: ", err)
+		}
+		defer func() {
+			if err := writeLog.close(); err != nil {
+				log.Println("Cannot close write log: ", err)
+			}
+		}()
+	}
+
+	opts, err := connectOpts()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	session, err := connect(opts)
 	if err != nil {
 		log.Fatalln("Cannot connect: ", err)
 	}
 
-	createTable(session)
-	time.Sleep(1 * time.Second)
-	insertRecords(session)
-	time.Sleep(1 * time.Second)
-	getNearestWithDistances(session)
-	time.Sleep(1 * time.Second)
-	getNearest(session)
-	time.Sleep(1 * time.Second)
-	getNearestByName("first", session)
+	switch cmd := flag.Arg(0); cmd {
+	case "", "demo":
+		if err := RunDemo(session, results); err != nil {
+			log.Fatalln(err)
+		}
+	case "serve":
+		if err := bootstrap(session); err != nil {
+			log.Fatalln(err)
+		}
+		log.Fatalln(serve(session, *httpAddr))
+	case "nested":
+		if err := runNestedDemo(session, results); err != nil {
+			log.Fatalln(err)
+		}
+	case "describe":
+		if err := describeTable(session, results, tableName); err != nil {
+			log.Fatalln("Cannot describe table: ", err)
+		}
+	case "migrate-index":
+		if err := migrateIndex(session, results, *confirm, *waitIndex); err != nil {
+			log.Fatalln("Cannot migrate index: ", err)
+		}
+	default:
+		log.Fatalln("Unknown command: ", cmd)
+	}
+}
+
+// RunDemo runs the whole example against session: it recreates the table and
+// its indexes, inserts the sample records and runs the nearest queries,
+// writing everything it prints to out. It stops at the first error.
+func RunDemo(session *r.Session, out io.Writer) error {
+	steps := []func(*r.Session, io.Writer) error{
+		func(session *r.Session, out io.Writer) error {
+			var geoIndexFn func(r.Term) interface{}
+			if *geoIndexFromCoords {
+				geoIndexFn = pointFromCoordinates
+			}
+			return createTable(session, out, *primaryKey, geoIndexFn)
+		},
+		func(session *r.Session, out io.Writer) error {
+			guard, err := guardFromFlags()
+			if err != nil {
+				return err
+			}
+			return insertRecords(context.Background(), session, out, insertOptions{
+				Durability: *durability,
+				Rate:       *insertRate,
+				Jitter:     *jitter,
+				Precision:  *precision,
+				Guard:      guard,
+			})
+		},
+		getNearestWithDistances,
+		getNearest,
+		func(session *r.Session, out io.Writer) error {
+			return getNearestByName("first", session, out)
+		},
+		pageNearest,
+		nearestPerLayer,
+		closestPerCategory,
+		getNearestFlaggingClose,
+		func(session *r.Session, out io.Writer) error {
+			if *primaryKey != "code" {
+				return nil
+			}
+			return getByCode("sf-1", session, out)
+		},
+		moveAndShowTimestamps,
+	}
+	for i, step := range steps {
+		if i > 0 {
+			time.Sleep(1 * time.Second)
+		}
+		if err := step(session, out); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func createTable(session *r.Session) {
-	fmt.Println("create table and index")
-	r.DB(DBName).TableDrop(tableName).Exec(session)
-	if err := r.DB(DBName).TableCreate(tableName).Exec(session); err != nil {
-		log.Fatalln("Cannot create table: ", err)
+// createTable creates the table, keyed on primaryKey, and its indexes. With
+// -drop=false an existing table is kept and only missing indexes are
+// created, so it can be re-run on a table that already has data.
+//
+// If geoIndexFn is not nil the area geo index is built from it instead of
+// the area field, see ensureGeoIndexFunc. An existing area index is kept
+// whatever it was built from, so changing the function needs -drop.
+func createTable(session *r.Session, out io.Writer, primaryKey string, geoIndexFn func(r.Term) interface{}) error {
+	fmt.Fprintln(out, "create table and index")
+	if *dropTable {
+		r.DB(DBName).TableDrop(tableName).Exec(session)
+	}
+	exists, err := hasTable(session, tableName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := r.DB(DBName).TableCreate(tableName, r.TableCreateOpts{
+			PrimaryKey: primaryKey,
+		}).Exec(session); err != nil {
+			return fmt.Errorf("cannot create table: %v", err)
+		}
+	}
+
+	for _, index := range tableIndexes {
+		ensure := index.ensure
+		if index.name == indexName && geoIndexFn != nil {
+			ensure = func(session *r.Session) error {
+				return ensureGeoIndexFunc(session, tableName, indexName, geoIndexFn)
+			}
+		}
+		if err := ensure(session); err != nil {
+			return fmt.Errorf("cannot create index: %v", err)
+		}
 	}
+	fmt.Fprintln(out, "")
+	return nil
+}
 
-	if err := r.DB(DBName).Table(tableName).IndexCreate(indexName, r.IndexCreateOpts{
-		Geo: true,
-	}).Exec(session); err != nil {
-		log.Fatalln("Cannot create index: ", err)
+func hasTable(session *r.Session, table string) (bool, error) {
+	var names []string
+	res, err := runQuery(session, r.DB(DBName).TableList())
+	if err != nil {
+		return false, err
+	}
+	if err = res.All(&names); err != nil {
+		return false, err
 	}
-	fmt.Println("")
+	for _, name := range names {
+		if name == table {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// insertOptions controls how insertRecords writes.
+type insertOptions struct {
+	// Durability is "hard" or "soft". Soft returns before the write is on
+	// disk, see -durability.
+	Durability string
+	// Rate caps inserts at this many records per second, 0 is unlimited.
+	Rate float64
+	// Jitter moves every point by up to this many meters before it is
+	// stored, 0 stores exact points.
+	Jitter float64
+	// Precision rounds coordinates to this many decimals before they are
+	// stored, a negative value leaves them as they are.
+	Precision int
+	// Guard, if set, is run on every record after jitter and rounding. A
+	// record it returns an error for is not inserted; see boundsGuard.
+	Guard func(Record) error
 }
 
-func insertRecords(session *r.Session) {
-	fmt.Println("insert records")
-	for _, record := range records {
-		if _, err := r.DB(DBName).Table(tableName).Insert(record).RunWrite(session); err != nil {
-			log.Println("Cannot create record: ", err)
+// insertRecords inserts the sample records. A record that fails is reported
+// and the rest are still inserted; cancelling ctx stops before the next one.
+// Records rejected by opts.Guard are skipped. Every failed or rejected record
+// is returned in Errors, a rejection wrapping the guard's error.
+func insertRecords(ctx context.Context, session *r.Session, out io.Writer, opts insertOptions) error {
+	fmt.Fprintln(out, "insert records")
+	pace := newPacer(opts.Rate)
+	defer pace.stop()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var errs Errors
+	for i, record := range records {
+		if err := pace.wait(ctx); err != nil {
+			return err
+		}
+		if opts.Jitter > 0 {
+			record.GeoSpatial = jitterPoint(record.GeoSpatial, opts.Jitter, rng)
+		}
+		if opts.Precision >= 0 {
+			record.GeoSpatial = roundPoint(record.GeoSpatial, opts.Precision)
+		}
+		if looksSwapped(record.GeoSpatial) {
+			fmt.Fprintf(out, "Warning: record %q at lon %v, lat %v looks like it has lon and lat swapped\n", record.Name, record.GeoSpatial.Lon, record.GeoSpatial.Lat)
+		}
+		if opts.Guard != nil {
+			if err := opts.Guard(record); err != nil {
+				fmt.Fprintln(out, "Rejected record: ", err)
+				errs = append(errs, RecordError{Index: i, Name: record.Name, Err: err})
+				continue
+			}
+		}
+		doc := r.Expr(record).Merge(map[string]interface{}{"created_at": r.Now()})
+		resp, err := r.DB(DBName).Table(tableName).Insert(doc, r.InsertOpts{Durability: opts.Durability}).RunWrite(session)
+		stats.observeInsert(resp.Inserted, err)
+		id := record.ID
+		if len(resp.GeneratedKeys) > 0 {
+			id = resp.GeneratedKeys[0]
+		} else if *primaryKey == "code" {
+			id = record.Code
+		}
+		logWrite("insert", id, err)
+		if err != nil {
+			fmt.Fprintln(out, "Cannot create record: ", err)
+			errs = append(errs, RecordError{Index: i, Name: record.Name, Err: err})
 		}
 	}
-	fmt.Println("")
+	fmt.Fprintln(out, "")
+	if errs.Any() {
+		return errs
+	}
+	return nil
 }
 
-func getNearestWithDistances(session *r.Session) {
-	fmt.Println("Get nearest records with distances")
+func getNearestWithDistances(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Get nearest records with distances")
 	var rows []*RecordWithDistance
 	query := r.Table(tableName).
-		GetNearest(types.Point{Lon: -122.4153346282659, Lat: 37.77874812639591}, r.GetNearestOpts{Index: indexName, MaxDist: 250, MaxResults: 1024, Unit: "mi"})
-	res, err := query.Run(session)
+		GetNearest(demoPoint, r.GetNearestOpts{Index: indexName, MaxDist: 250, MaxResults: 1024, Unit: "mi"}).
+		Filter(resultNotDeleted())
+	res, err := runQuery(session, query, printingTo(out))
 	if err != nil {
-		log.Println(err)
-	} else if err = res.All(&rows); err != nil {
-		log.Println(err)
+		return err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return err
 	}
+	rows = rows[:displayCount(len(rows))]
 	for k := range rows {
-		printStructAsJSON(rows[k])
+		printResultWithDistance(out, rows[k])
 	}
-	fmt.Println("")
+	fmt.Fprintln(out, "")
+	return nil
 }
 
 // [
@@ -140,48 +442,243 @@ func getNearestWithDistances(session *r.Session) {
 //   }
 // ]
 
-func getNearest(session *r.Session) {
-	fmt.Println("Get just the nearest records")
+func getNearest(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Get just the nearest records")
 	var rows []*Record
 	query := r.Table(tableName).
-		GetNearest(types.Point{Lon: -122.4153346282659, Lat: 37.77874812639591}, r.GetNearestOpts{Index: indexName, MaxDist: 100, MaxResults: 1024, Unit: "mi"}).
+		GetNearest(demoPoint, r.GetNearestOpts{Index: indexName, MaxDist: 100, MaxResults: 1024, Unit: "mi"}).
 		Do(func(doc r.Term) r.Term {
 			return doc.Field("doc")
-		})
-	res, err := query.Run(session)
+		}).Filter(notDeleted())
+	res, err := runQuery(session, query, printingTo(out))
 	if err != nil {
-		log.Println(err)
-	} else if err = res.All(&rows); err != nil {
-		log.Println(err)
+		return err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return err
 	}
+	rows = rows[:displayCount(len(rows))]
 	for k := range rows {
-		printStructAsJSON(rows[k])
+		printRecord(out, rows[k])
 	}
-	fmt.Println("")
+	fmt.Fprintln(out, "")
+	return nil
 }
 
 // You can chain and filter them afterwards
-func getNearestByName(name string, session *r.Session) {
-	fmt.Println("Chain some additional filters")
+func getNearestByName(name string, session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Chain some additional filters")
 	var rows []*Record
 	query := r.Table(tableName).
-		GetNearest(types.Point{Lon: -122.4153346282659, Lat: 37.77874812639591}, r.GetNearestOpts{Index: indexName, MaxDist: 100, MaxResults: 1024, Unit: "mi"}).
+		GetNearest(demoPoint, r.GetNearestOpts{Index: indexName, MaxDist: 100, MaxResults: 1024, Unit: "mi"}).
 		Do(func(doc r.Term) r.Term {
 			return doc.Field("doc")
-		}).Filter(r.Row.Field("name").Eq(name))
-	res, err := query.Run(session)
+		}).Filter(notDeleted()).Filter(r.Row.Field("name").Eq(name))
+	res, err := runQuery(session, query, printingTo(out))
+	if err != nil {
+		return err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return err
+	}
+	rows = rows[:displayCount(len(rows))]
+	for k := range rows {
+		printRecord(out, rows[k])
+	}
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+// Fetch a record by its natural key, which only works when the table is keyed on code
+func getByCode(code string, session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Get a record by its natural key")
+	var rec Record
+	res, err := runQuery(session, r.Table(tableName).Get(code), printingTo(out))
+	if err != nil {
+		return err
+	}
+	if err = res.One(&rec); err != nil {
+		return err
+	}
+	printRecord(out, &rec)
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+// getByName returns the records named name, in no particular order. It looks
+// them up through the name index instead of scanning GetNearest candidates
+// like getNearestByName, so use it when distance doesn't matter.
+func getByName(session *r.Session, name string) ([]*Record, error) {
+	var rows []*Record
+	res, err := runQuery(session, r.Table(tableName).GetAllByIndex(nameIndex, name).Filter(notDeleted()))
+	if err != nil {
+		return nil, err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// nearestPerLayer runs the same nearest query once per layer of the sample
+// records, each answered from the one table.
+func nearestPerLayer(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Get nearest records per layer")
+	for _, layer := range []string{"restaurants", "hotels"} {
+		res, err := nearestInLayer(session, indexName, layer, indexName, demoPoint, r.GetNearestOpts{MaxDist: 250, MaxResults: 1024, Unit: "mi"}, printingTo(out))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "layer", layer)
+		rows := res.Records[:displayCount(len(res.Records))]
+		for k := range rows {
+			printResultWithDistance(out, rows[k])
+		}
+	}
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+// closestPerCategory prints the closest record of each category of the
+// sample records, in category order.
+func closestPerCategory(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Get the closest record per category")
+	closest, err := nearestPerCategory(session, indexName, demoPoint, r.GetNearestOpts{MaxDist: 250, MaxResults: 1024, Unit: "mi"}, printingTo(out))
+	if err != nil {
+		return err
+	}
+	categories := make([]string, 0, len(closest))
+	for category := range closest {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintln(out, "category", category)
+		printResultWithDistance(out, closest[category])
+	}
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+func getNearestFlaggingClose(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Get nearest records flagging those within 1 mi")
+	res, err := nearestFlaggingClose(session, indexName, demoPoint, r.GetNearestOpts{MaxDist: 250, MaxResults: 1024, Unit: "mi"}, 1, printingTo(out))
 	if err != nil {
-		log.Println(err)
-	} else if err = res.All(&rows); err != nil {
-		log.Println(err)
+		return err
 	}
+	rows := res.Records[:displayCount(len(res.Records))]
 	for k := range rows {
-		printStructAsJSON(rows[k])
+		printResultWithDistance(out, rows[k])
+	}
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+// moveAndShowTimestamps moves the first record a little north and reads it
+// back, showing the server-set timestamps decoded as time.Time. It runs last
+// since the move changes the distances the other steps print.
+func moveAndShowTimestamps(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Move a record and read back its timestamps")
+	recs, err := getByName(session, "first")
+	if err != nil {
+		return err
+	}
+	if len(recs) == 0 {
+		return fmt.Errorf("no record named %q", "first")
+	}
+	key := recs[0].ID
+	if *primaryKey == "code" {
+		key = recs[0].Code
+	}
+	p := recs[0].GeoSpatial
+	p.Lat += 0.0001
+	if err := moveRecord(session, key, p); err != nil {
+		return err
+	}
+
+	var rec Record
+	res, err := runQuery(session, r.Table(tableName).Get(key), printingTo(out))
+	if err != nil {
+		return err
+	}
+	if err = res.One(&rec); err != nil {
+		return err
+	}
+	if rec.CreatedAt != nil && rec.UpdatedAt != nil {
+		fmt.Fprintf(out, "%s: created %s, updated %s later\n", rec.Name, rec.CreatedAt.Format(time.RFC3339), rec.UpdatedAt.Sub(*rec.CreatedAt))
+	}
+	printRecord(out, &rec)
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+// nearestExcluding returns the nearest records on index except those whose
+// id is in excludeIDs. The ids are filtered out after GetNearest, so excluded
+// records still count towards opts.MaxResults.
+func nearestExcluding(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, excludeIDs []string, runOpts ...r.RunOpts) ([]*Record, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	rows, err := runNearest[*Record](session, nearestExcludingQuery(p, opts, excludeIDs), p, opts, runOpts...)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("")
+	return rows, nil
 }
 
-func printStructAsJSON(v interface{}) {
+func nearestExcludingQuery(p types.Point, opts r.GetNearestOpts, excludeIDs []string) r.Term {
+	return r.Table(tableName).
+		GetNearest(p, opts).
+		Do(func(doc r.Term) r.Term {
+			return doc.Field("doc")
+		}).Filter(notDeleted()).
+		// r.Row can't be used here: Contains wraps its argument in a
+		// function of its own, so r.Row would be each excluded id rather
+		// than the record.
+		Filter(func(doc r.Term) r.Term {
+			return r.Not(r.Expr(excludeIDs).Contains(doc.Field("id")))
+		})
+}
+
+// Page through the nearest records two at a time, excluding the ones already seen
+func pageNearest(session *r.Session, out io.Writer) error {
+	fmt.Fprintln(out, "Page through nearest records")
+	const pageSize = 2
+	seen := []string{}
+	for page := 1; ; page++ {
+		// the seen records are still among the nearest, so ask for that many more
+		rows, err := nearestExcluding(session, indexName, demoPoint, r.GetNearestOpts{MaxDist: 100, MaxResults: len(seen) + pageSize, Unit: "mi"}, seen, printingTo(out))
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		if len(rows) > pageSize {
+			rows = rows[:pageSize]
+		}
+		fmt.Fprintln(out, "page", page)
+		for k := range rows {
+			printRecord(out, rows[k])
+			seen = append(seen, rows[k].ID)
+		}
+	}
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+// displayCount returns how many of n decoded rows should be printed given -limit.
+func displayCount(n int) int {
+	if *limit > 0 && *limit < n {
+		return *limit
+	}
+	return n
+}
+
+func printStructAsJSON(w io.Writer, v interface{}) {
 	b, _ := json.MarshalIndent(v, "", "  ")
-	log.Println(string(b))
+	if _, err := fmt.Fprintln(w, string(b)); err != nil {
+		log.Println("Cannot write result: ", err)
+	}
 }
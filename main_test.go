@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+// testSession connects to the RethinkDB at $RETHINKDB_ADDR, for example
+// localhost:28015, and skips the test or benchmark when it isn't set. The
+// demo database is created if it is missing.
+func testSession(tb testing.TB) *r.Session {
+	tb.Helper()
+	addr := os.Getenv("RETHINKDB_ADDR")
+	if addr == "" {
+		tb.Skip("RETHINKDB_ADDR not set, skipping test against a live RethinkDB")
+	}
+	session, err := connect(r.ConnectOpts{Address: addr, Database: DBName})
+	if err != nil {
+		tb.Fatal("Cannot connect: ", err)
+	}
+	tb.Cleanup(func() { session.Close() })
+	if err := ensureDatabase(session, DBName); err != nil {
+		tb.Fatal("Cannot create database: ", err)
+	}
+	return session
+}
+
+// TestCreateTableTwice runs createTable with -drop=false on an existing table,
+// which must reuse the table and its indexes instead of failing on them.
+func TestCreateTableTwice(t *testing.T) {
+	session := testSession(t)
+	defer func(drop bool) { *dropTable = drop }(*dropTable)
+	*dropTable = false
+	for i := 0; i < 2; i++ {
+		if err := createTable(session, io.Discard, *primaryKey, nil); err != nil {
+			t.Fatalf("createTable, run %d: %v", i+1, err)
+		}
+	}
+	for _, index := range tableIndexes {
+		exists, err := hasIndex(session, tableName, index.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Errorf("index %s is missing after the second run", index.name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the query latency
+// histogram.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metrics holds the counters served on /metrics in the Prometheus text
+// format. Recording is a few atomic adds, plus a read lock to find the
+// counter of a query type, so it is safe and cheap to call from every
+// request.
+type metrics struct {
+	mu      sync.RWMutex
+	queries map[string]*atomic.Uint64
+
+	inserts     atomic.Uint64
+	errors      atomic.Uint64
+	feedDropped atomic.Uint64
+
+	buckets    []atomic.Uint64
+	latencySum atomic.Uint64 // float64 bits, in seconds
+	latencyN   atomic.Uint64
+}
+
+var stats = newMetrics()
+
+func newMetrics() *metrics {
+	return &metrics{
+		queries: map[string]*atomic.Uint64{},
+		buckets: make([]atomic.Uint64, len(latencyBuckets)),
+	}
+}
+
+// observeQuery records one query of the given kind, how long it took and
+// whether it failed.
+func (m *metrics) observeQuery(kind string, took time.Duration, err error) {
+	m.queryCounter(kind).Add(1)
+	if err != nil {
+		m.errors.Add(1)
+	}
+	secs := took.Seconds()
+	for i, bound := range latencyBuckets {
+		if secs <= bound {
+			m.buckets[i].Add(1)
+		}
+	}
+	for {
+		old := m.latencySum.Load()
+		sum := math.Float64bits(math.Float64frombits(old) + secs)
+		if m.latencySum.CompareAndSwap(old, sum) {
+			break
+		}
+	}
+	m.latencyN.Add(1)
+}
+
+func (m *metrics) queryCounter(kind string) *atomic.Uint64 {
+	m.mu.RLock()
+	c, ok := m.queries[kind]
+	m.mu.RUnlock()
+	if ok {
+		return c
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok = m.queries[kind]; !ok {
+		c = new(atomic.Uint64)
+		m.queries[kind] = c
+	}
+	return c
+}
+
+func (m *metrics) observeInsert(n int, err error) {
+	m.inserts.Add(uint64(n))
+	if err != nil {
+		m.errors.Add(1)
+	}
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer) {
+	fmt.Fprintln(w, "# HELP geo_queries_total Queries run, by type.")
+	fmt.Fprintln(w, "# TYPE geo_queries_total counter")
+	m.mu.RLock()
+	kinds := make([]string, 0, len(m.queries))
+	for kind := range m.queries {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "geo_queries_total{type=%q} %d\n", kind, m.queries[kind].Load())
+	}
+	m.mu.RUnlock()
+
+	fmt.Fprintln(w, "# HELP geo_inserts_total Records inserted.")
+	fmt.Fprintln(w, "# TYPE geo_inserts_total counter")
+	fmt.Fprintf(w, "geo_inserts_total %d\n", m.inserts.Load())
+
+	fmt.Fprintln(w, "# HELP geo_errors_total Failed queries and inserts.")
+	fmt.Fprintln(w, "# TYPE geo_errors_total counter")
+	fmt.Fprintf(w, "geo_errors_total %d\n", m.errors.Load())
+
+	fmt.Fprintln(w, "# HELP geo_feed_dropped_total Changefeed changes dropped because the consumer was too slow.")
+	fmt.Fprintln(w, "# TYPE geo_feed_dropped_total counter")
+	fmt.Fprintf(w, "geo_feed_dropped_total %d\n", m.feedDropped.Load())
+
+	fmt.Fprintln(w, "# HELP geo_query_duration_seconds Query latency.")
+	fmt.Fprintln(w, "# TYPE geo_query_duration_seconds histogram")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "geo_query_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.buckets[i].Load())
+	}
+	n := m.latencyN.Load()
+	fmt.Fprintf(w, "geo_query_duration_seconds_bucket{le=\"+Inf\"} %d\n", n)
+	fmt.Fprintf(w, "geo_query_duration_seconds_sum %g\n", math.Float64frombits(m.latencySum.Load()))
+	fmt.Fprintf(w, "geo_query_duration_seconds_count %d\n", n)
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	stats.writeTo(w)
+}
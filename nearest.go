@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// ErrPartialResults is returned with the rows received so far when a query's
+// context expires before all of its results were read.
+var ErrPartialResults = errors.New("deadline exceeded before all results were read, results are partial")
+
+// ErrNoNearby is returned when no record is within MaxDist of the query point.
+var ErrNoNearby = errors.New("no record within the maximum distance")
+
+// NearestResult is the outcome of a nearest query together with the query
+// point and unit that produced it, so a serialized result describes itself.
+type NearestResult struct {
+	Query   types.Point           `json:"query"`
+	Unit    string                `json:"unit"`
+	Records []*RecordWithDistance `json:"records"`
+
+	// Next is the page token for the next page of a paginated query, see
+	// nearestPage. It is empty on the last page and for unpaginated queries.
+	Next string `json:"next,omitempty"`
+}
+
+func newNearestResult(p types.Point, opts r.GetNearestOpts, rows []*RecordWithDistance) *NearestResult {
+	unit, _ := opts.Unit.(string)
+	if unit == "" {
+		unit = defaultUnit
+	}
+	if *geohashPrecision > 0 {
+		for _, row := range rows {
+			row.Geohash = geohashEncode(row.Doc.GeoSpatial, *geohashPrecision)
+		}
+	}
+	if len(outputUnits) > 0 {
+		for _, row := range rows {
+			// The query accepted unit, so convertDistance knows it.
+			row.Units, _ = multiUnitDist(row.Dist, unit, outputUnits)
+		}
+	}
+	setRanks(rows)
+	return &NearestResult{Query: p, Unit: unit, Records: rows}
+}
+
+// setRanks numbers rows by their position, the first being rank 1. The
+// query functions set ranks on what they return, and anything that reorders
+// or drops rows afterwards, such as rankWeighted or applyProcessors, sets
+// them again, so Rank always matches the order rows are in.
+func setRanks(rows []*RecordWithDistance) {
+	for i, row := range rows {
+		row.Rank = i + 1
+	}
+}
+
+// overflowBand is the bucketByDistance band of rows past the last edge.
+const overflowBand = "overflow"
+
+// bucketByDistance groups rows into distance bands for legends: with edges
+// 50, 100, 250 the bands are "0-50", "50-100" and "100-250", plus "overflow"
+// for rows at 250 or more. Edges must be ascending and in the rows' unit.
+// A band holds distances from its lower edge up to but not including its
+// upper one, so a row at exactly 50 is in "50-100". Rows keep their order
+// within a band, and empty bands are left out.
+func bucketByDistance(recs []*RecordWithDistance, edges []float64) map[string][]*RecordWithDistance {
+	labels := make([]string, len(edges))
+	lower := 0.0
+	for i, edge := range edges {
+		labels[i] = strconv.FormatFloat(lower, 'f', -1, 64) + "-" + strconv.FormatFloat(edge, 'f', -1, 64)
+		lower = edge
+	}
+	bands := map[string][]*RecordWithDistance{}
+	for _, rec := range recs {
+		band := overflowBand
+		if i := sort.SearchFloat64s(edges, rec.Dist); i < len(edges) {
+			if edges[i] == rec.Dist {
+				i++
+			}
+			if i < len(edges) {
+				band = labels[i]
+			}
+		}
+		bands[band] = append(bands[band], rec)
+	}
+	return bands
+}
+
+// nearest runs GetNearest on the geo index named index around p and returns
+// the rows with their distances, closest first. index replaces opts.Index, so
+// tables with several geo indexes (one per layer, say) can pick one per
+// query, and it is checked with checkGeoIndex before the query runs: a wrong
+// name fails with ErrNoGeoIndex rather than a server error. The nearest
+// functions built on it take index the same way. runOpts are passed on to
+// Run, see runQuery; the other nearest functions take them the same way.
+func nearest(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	rows, err := nearestInTable(session, tableName, p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return newNearestResult(p, opts, rows), nil
+}
+
+func nearestInTable(session *r.Session, table string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) ([]*RecordWithDistance, error) {
+	return nearestFrom[*RecordWithDistance](session, table, p, opts, runOpts...)
+}
+
+// Nearest runs GetNearest on index around p and decodes the rows into T
+// instead of RecordWithDistance, for callers with their own document type.
+// Each row is {dist, doc}, so T typically has a dist field and a doc field
+// holding the caller's document struct.
+func Nearest[T any](session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) ([]T, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	return nearestFrom[T](session, tableName, p, opts, runOpts...)
+}
+
+func nearestFrom[T any](session *r.Session, table string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) ([]T, error) {
+	var rows []T
+	query := nearestQuery(table, p, opts)
+	start := time.Now()
+	res, err := runQuery(session, query, runOpts...)
+	if isIndexNotReady(err) {
+		index, _ := opts.Index.(string)
+		if err = awaitIndex(session, table, index); err == nil {
+			res, err = runQuery(session, query, runOpts...)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return nil, err
+	}
+	logSlowQuery(p, opts, time.Since(start), len(rows))
+	return rows, nil
+}
+
+// nearestQuery is the GetNearest query nearest runs on table, without the
+// soft-deleted records.
+func nearestQuery(table string, p types.Point, opts r.GetNearestOpts) r.Term {
+	return r.Table(table).GetNearest(p, opts).Filter(resultNotDeleted())
+}
+
+// GeoJSONRecord is a nearest result whose geometry was converted to plain
+// GeoJSON by RethinkDB, without the $reql_type$ marker.
+type GeoJSONRecord struct {
+	Name     string          `gorethink:"name" json:"name"`
+	Dist     float64         `gorethink:"dist" json:"dist"`
+	Geometry json.RawMessage `gorethink:"-" json:"geometry"`
+
+	// RawGeometry is the GeoJSON as the server serialized it, Geometry is
+	// filled from it after decoding.
+	RawGeometry string `gorethink:"geometry" json:"-"`
+}
+
+// nearestGeoJSON is like nearest but has the server convert each area with
+// ToGeoJSON in the projection, so no conversion happens in Go.
+func nearestGeoJSON(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) ([]*GeoJSONRecord, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	rows, err := runNearest[*GeoJSONRecord](session, nearestGeoJSONQuery(p, opts), p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		row.Geometry = json.RawMessage(row.RawGeometry)
+	}
+	return rows, nil
+}
+
+func nearestGeoJSONQuery(p types.Point, opts r.GetNearestOpts) r.Term {
+	return nearestQuery(tableName, p, opts).
+		Map(func(row r.Term) interface{} {
+			return map[string]interface{}{
+				"name":     row.Field("doc").Field("name"),
+				"dist":     row.Field("dist"),
+				"geometry": row.Field("doc").Field("area").ToGeoJSON().ToJSON(),
+			}
+		})
+}
+
+// nearestInCategory returns the records of a category within maxDist meters
+// of p, closest first. Instead of GetNearest followed by a filter, it fetches
+// the category through its secondary index and computes haversine distances
+// in Go. Distances and maxDist are in meters.
+//
+// Use it when the category is far more selective than the distance bound: a
+// category of a few dozen records is cheaper to fetch whole than scanning
+// every geo candidate for it. When the category is large, or most of it is
+// far away, GetNearest with a post-filter (see getNearestByName) wins since
+// the geo index only returns what is near.
+func nearestInCategory(session *r.Session, category string, p types.Point, maxDist float64) (*NearestResult, error) {
+	var recs []*Record
+	query := r.Table(tableName).GetAllByIndex(categoryIndex, category).Filter(notDeleted())
+	res, err := runQuery(session, query)
+	if err != nil {
+		return nil, err
+	}
+	if err = decodeAll(res, &recs); err != nil {
+		return nil, err
+	}
+
+	var rows []*RecordWithDistance
+	for _, rec := range recs {
+		if d := haversine(p, rec.GeoSpatial); d <= maxDist {
+			rows = append(rows, &RecordWithDistance{Dist: d, Doc: rec})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Dist < rows[j].Dist })
+	return newNearestResult(p, r.GetNearestOpts{Unit: "m"}, rows), nil
+}
+
+// nearestBruteForce returns the records within maxDist of p, closest first,
+// without any index: it streams the whole table and computes haversine
+// distances in Go. maxDist and the returned distances are in unit.
+//
+// This is O(n) in the size of the table, every record crossing the network
+// on every call. Use it only on small tables, or on tables that have no geo
+// index yet; anything else should go through GetNearest.
+func nearestBruteForce(session *r.Session, p types.Point, maxDist float64, unit string) ([]*RecordWithDistance, error) {
+	maxMeters, err := convertDistance(maxDist, unit, "m")
+	if err != nil {
+		return nil, err
+	}
+	res, err := runQuery(session, r.Table(tableName).Filter(notDeleted()))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var rows []*RecordWithDistance
+	var raw interface{}
+	for res.Next(&raw) {
+		rec := new(Record)
+		if err := decodeRow(raw, rec); err != nil {
+			return nil, err
+		}
+		if d := haversine(p, rec.GeoSpatial); d <= maxMeters {
+			dist, _ := convertDistance(d, "m", unit)
+			rows = append(rows, &RecordWithDistance{Dist: dist, Doc: rec})
+		}
+		raw = nil
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Dist < rows[j].Dist })
+	setRanks(rows)
+	return rows, nil
+}
+
+// nearestPerCategory returns the closest record of each category among the
+// nearest results around p, keyed by category; records without one are under
+// "". It is one GetNearest query grouped in Go rather than with Group on the
+// server: GetNearest already returns rows closest first and capped at
+// MaxResults, so keeping the first row per category is a single pass, while
+// Group("category") would need a Min("dist") per group and come back as
+// grouped data to decode. The cost is that every row within MaxDist is sent
+// to the client, and a category whose records all lie past the first
+// MaxResults rows is missing from the map; for many rows and few categories,
+// group on the server instead.
+func nearestPerCategory(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (map[string]*RecordWithDistance, error) {
+	res, err := nearest(session, index, p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	closest := map[string]*RecordWithDistance{}
+	for _, row := range res.Records {
+		if _, ok := closest[row.Doc.Category]; !ok {
+			closest[row.Doc.Category] = row
+		}
+	}
+	return closest, nil
+}
+
+// nearestInLayer returns the records of layer within opts.MaxDist of p,
+// closest first, in the rows shape GetNearest returns. A geo index can't be
+// compound, so there is no index on (layer, area); instead the layer is
+// fetched through its secondary index and distances are computed with
+// Distance on the server, so only matches travel to the client. Distances
+// are to the geometry at path, a dot separated field path such as "area" or
+// "location.area" as for createGeoIndex, which need not be named like the
+// geo index: index replaces opts.Index and is checked like nearest does.
+// For an index built with a function, such as pointFromCoordinates, pass the
+// field holding the geometry the function reads. Like nearestInCategory this pays off when the layer is small next to the table;
+// for a layer holding most records, GetNearest with a post-filter on layer is
+// cheaper.
+func nearestInLayer(session *r.Session, index, layer, path string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	rows, err := runNearest[*RecordWithDistance](session, nearestInLayerQuery(layer, path, p, opts), p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return newNearestResult(p, opts, rows), nil
+}
+
+func nearestInLayerQuery(layer, path string, p types.Point, opts r.GetNearestOpts) r.Term {
+	maxDist := opts.MaxDist
+	if maxDist == nil {
+		maxDist = defaultMaxDist
+	}
+	maxResults := opts.MaxResults
+	if maxResults == nil {
+		maxResults = defaultMaxResults
+	}
+	unit := opts.Unit
+	if unit == nil {
+		unit = defaultUnit
+	}
+	fields := strings.Split(path, ".")
+	return r.Table(tableName).GetAllByIndex(layerIndex, layer).
+		Filter(notDeleted()).
+		Map(func(doc r.Term) interface{} {
+			return map[string]interface{}{
+				"dist": fieldPath(doc, fields).Distance(p, r.DistanceOpts{Unit: unit}),
+				"doc":  doc,
+			}
+		}).
+		Filter(r.Row.Field("dist").Le(maxDist)).
+		OrderBy("dist").
+		Limit(maxResults)
+}
+
+// nearestFlaggingClose is nearest on index with a very_close field on each
+// row, true when the row is within threshold of p, in opts.Unit. The flag is
+// computed by the server with a Merge on the GetNearest rows, so it arrives
+// with the results instead of taking another query, and the same pattern
+// works for any other value derived from dist or doc.
+func nearestFlaggingClose(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, threshold float64, runOpts ...r.RunOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	rows, err := runNearest[*RecordWithDistance](session, nearestFlaggingCloseQuery(p, opts, threshold), p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return newNearestResult(p, opts, rows), nil
+}
+
+func nearestFlaggingCloseQuery(p types.Point, opts r.GetNearestOpts, threshold float64) r.Term {
+	return nearestQuery(tableName, p, opts).
+		Merge(func(row r.Term) interface{} {
+			return r.Branch(row.Field("dist").Le(threshold),
+				map[string]interface{}{"very_close": true},
+				map[string]interface{}{"very_close": false})
+		})
+}
+
+// nearestPartial is like nearest but gives up when ctx is done, returning the
+// rows read until then together with ErrPartialResults instead of discarding
+// them. Rows are read with cursor Next on a separate goroutine so a slow
+// batch can't hold the caller past its deadline; that goroutine closes the
+// cursor once it stops.
+//
+// GetNearest builds its result server-side before sending it, so the
+// deadline bounds fetching and decoding the rows, not the index lookup.
+func nearestPartial(ctx context.Context, session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	start := time.Now()
+	res, err := runQuery(session, nearestQuery(tableName, p, opts), runOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	rowc := make(chan *RecordWithDistance)
+	errc := make(chan error, 1)
+	go func() {
+		defer res.Close()
+		defer close(rowc)
+		for {
+			row := new(RecordWithDistance)
+			if ok, err := nextRow(res, row); !ok {
+				errc <- err
+				return
+			}
+			select {
+			case rowc <- row:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var rows []*RecordWithDistance
+	done := func(err error) (*NearestResult, error) {
+		logSlowQuery(p, opts, time.Since(start), len(rows))
+		return newNearestResult(p, opts, rows), err
+	}
+	for {
+		select {
+		case row, ok := <-rowc:
+			if !ok {
+				select {
+				case err := <-errc:
+					return done(err)
+				default:
+					return done(ErrPartialResults)
+				}
+			}
+			rows = append(rows, row)
+		case <-ctx.Done():
+			return done(ErrPartialResults)
+		}
+	}
+}
+
+// nearestExpanding runs nearest and, whenever the result filled MaxResults,
+// which means there may be more records within MaxDist, runs it again with
+// MaxResults doubled, up to maxCap. The returned result is complete up to
+// MaxDist unless it holds maxCap records. Soft-deleted records are dropped
+// after MaxResults is applied, so deleted records among the candidates can
+// make a capped result look complete.
+//
+// Each expansion is another full round trip that recomputes the earlier
+// results too, so a query that needs k doublings costs k+1 queries. Start
+// from a MaxResults that is usually enough and keep maxCap modest.
+func nearestExpanding(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, maxCap int, runOpts ...r.RunOpts) (*NearestResult, error) {
+	n, ok := opts.MaxResults.(int)
+	if !ok || n < 1 {
+		// RethinkDB's default
+		n = 100
+	}
+	for {
+		if n > maxCap {
+			n = maxCap
+		}
+		opts.MaxResults = n
+		res, err := nearest(session, index, p, opts, runOpts...)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Records) < n || n >= maxCap {
+			return res, nil
+		}
+		n *= 2
+	}
+}
+
+// nearestStable is nearest on index with ties in distance broken by record
+// id on the server, so equally distant records come back in the same order
+// on every run.
+//
+// The tiebreak can't live in the geo index: a geo index function has to
+// return a geometry (or an array of them for a multi index), not a compound
+// value the way a plain index function can, so there is no sort key to carry
+// along. Instead the GetNearest array is ordered with OrderBy on dist and
+// then doc.id. It's a sort of at most MaxResults rows, done server-side, so
+// nothing extra is pulled to the client.
+func nearestStable(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	rows, err := runNearest[*RecordWithDistance](session, nearestStableQuery(p, opts), p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return newNearestResult(p, opts, rows), nil
+}
+
+func nearestStableQuery(p types.Point, opts r.GetNearestOpts) r.Term {
+	return nearestQuery(tableName, p, opts).
+		OrderBy(r.Asc("dist"), r.Asc(func(row r.Term) r.Term {
+			return row.Field("doc").Field("id")
+		}))
+}
+
+// nearestOne returns the single closest record to p that isn't soft-deleted,
+// or ErrNoNearby if none is within opts.MaxDist. Deleted records closer than
+// it are skipped by over-fetching, see nearestLive. opts.MaxResults is
+// ignored.
+func nearestOne(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*RecordWithDistance, error) {
+	res, err := nearestLive(session, index, p, 1, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrNoNearby
+	}
+	return res.Records[0], nil
+}
+
+// kthNearest returns the k-th closest live record to p, k counting from 1,
+// so k 2 skips the closest one, for example the query point's own record.
+// It returns ErrNoNearby if fewer than k records that aren't soft-deleted are
+// within opts.MaxDist. opts.MaxResults is ignored.
+func kthNearest(session *r.Session, index string, p types.Point, k int, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*RecordWithDistance, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("invalid k %d, want 1 or more", k)
+	}
+	res, err := nearestLive(session, index, p, k, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) < k {
+		return nil, ErrNoNearby
+	}
+	return res.Records[k-1], nil
+}
+
+// nearestLive returns the k closest records to p that aren't soft-deleted,
+// closest first, or fewer when there aren't k within opts.MaxDist. GetNearest
+// applies MaxResults before the deleted filter, so asking it for k results
+// comes back short whenever deleted records are among the k nearest.
+// Instead it asks for k candidates and, while the candidates filled
+// MaxResults but fewer than k of them are live, asks again for twice as
+// many, up to -max-results-cap. opts.MaxResults is ignored.
+func nearestLive(session *r.Session, index string, p types.Point, k int, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	var live liveCandidates
+	var n int
+	start := time.Now()
+	for n = k; ; n *= 2 {
+		if *maxResultsCap > 0 && n > *maxResultsCap {
+			n = *maxResultsCap
+		}
+		opts.MaxResults = n
+		live = liveCandidates{}
+		res, err := runQuery(session, nearestLiveQuery(p, opts, k), runOpts...)
+		if err != nil {
+			return nil, err
+		}
+		if err = res.One(&live); err != nil {
+			return nil, err
+		}
+		if live.Rows, err = decodeRows[*RecordWithDistance](live.RawRows); err != nil {
+			return nil, err
+		}
+		if len(live.Rows) == k || live.Candidates < n || n == *maxResultsCap {
+			break
+		}
+	}
+	logSlowQuery(p, opts, time.Since(start), len(live.Rows))
+	return newNearestResult(p, opts, live.Rows), nil
+}
+
+// liveCandidates is what nearestLiveQuery returns: up to k live rows and how
+// many candidates GetNearest returned.
+type liveCandidates struct {
+	Rows       []*RecordWithDistance `gorethink:"-"`
+	Candidates int                   `gorethink:"candidates"`
+
+	// RawRows are the rows as the server sent them, Rows is decoded from
+	// them with decodeRows.
+	RawRows []interface{} `gorethink:"rows"`
+}
+
+func nearestLiveQuery(p types.Point, opts r.GetNearestOpts, k int) r.Term {
+	return r.Table(tableName).GetNearest(p, opts).Do(func(candidates r.Term) interface{} {
+		// Not resultNotDeleted: the server refuses r.Row nested inside
+		// another function.
+		rows := candidates.Filter(func(row r.Term) r.Term {
+			return row.Field("doc").Field("deleted").Default(false).Eq(false)
+		})
+		return map[string]interface{}{
+			"rows":       rows.Limit(k),
+			"candidates": candidates.Count(),
+		}
+	})
+}
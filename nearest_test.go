@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// insertDeletedNearest inserts three records in a line east of far, a point
+// away from the demo data, the closest of them soft-deleted, and removes
+// them again when t is done.
+func insertDeletedNearest(t *testing.T, session *r.Session) types.Point {
+	t.Helper()
+	defer func(drop bool) { *dropTable = drop }(*dropTable)
+	*dropTable = false
+	if err := createTable(session, io.Discard, *primaryKey, nil); err != nil {
+		t.Fatal("Cannot create table: ", err)
+	}
+	far := types.Point{Lon: 100, Lat: -40}
+	recs := []Record{
+		{ID: "test-deleted", Name: "deleted", GeoSpatial: types.Point{Lon: 100.001, Lat: -40}, Deleted: true},
+		{ID: "test-first", Name: "first", GeoSpatial: types.Point{Lon: 100.002, Lat: -40}},
+		{ID: "test-second", Name: "second", GeoSpatial: types.Point{Lon: 100.003, Lat: -40}},
+	}
+	if err := r.Table(tableName).Insert(recs, r.InsertOpts{Conflict: "replace"}).Exec(session); err != nil {
+		t.Fatal("Cannot insert records: ", err)
+	}
+	t.Cleanup(func() {
+		r.Table(tableName).GetAll("test-deleted", "test-first", "test-second").Delete().Exec(session)
+	})
+	return far
+}
+
+func TestKthNearestSkipsDeleted(t *testing.T) {
+	session := testSession(t)
+	far := insertDeletedNearest(t, session)
+	opts := r.GetNearestOpts{MaxDist: 1000}
+	for k, want := range map[int]string{1: "first", 2: "second"} {
+		row, err := kthNearest(session, indexName, far, k, opts)
+		if err != nil {
+			t.Fatalf("kthNearest(%d): %v", k, err)
+		}
+		if row.Doc.Name != want {
+			t.Errorf("kthNearest(%d) = %q, want %q", k, row.Doc.Name, want)
+		}
+	}
+	if _, err := kthNearest(session, indexName, far, 3, opts); err != ErrNoNearby {
+		t.Errorf("kthNearest(3) error = %v, want ErrNoNearby", err)
+	}
+}
+
+func TestNearestOneSkipsDeleted(t *testing.T) {
+	session := testSession(t)
+	far := insertDeletedNearest(t, session)
+	row, err := nearestOne(session, indexName, far, r.GetNearestOpts{MaxDist: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Doc.Name != "first" {
+		t.Errorf("nearestOne = %q, want %q", row.Doc.Name, "first")
+	}
+}
+
+func TestBucketByDistanceEdges(t *testing.T) {
+	var recs []*RecordWithDistance
+	for _, d := range []float64{0, 49.9, 50, 99.99, 100, 250, 1000, 10} {
+		recs = append(recs, &RecordWithDistance{Dist: d})
+	}
+	bands := bucketByDistance(recs, []float64{50, 100, 250})
+
+	// Each edge belongs to the band above it, and 250 to the overflow.
+	want := map[string][]float64{
+		"0-50":       {0, 49.9, 10},
+		"50-100":     {50, 99.99},
+		"100-250":    {100},
+		overflowBand: {250, 1000},
+	}
+	got := make(map[string][]float64, len(bands))
+	for band, rows := range bands {
+		for _, row := range rows {
+			got[band] = append(got[band], row.Dist)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bucketByDistance = %v, want %v", got, want)
+	}
+}
+
+func TestBucketByDistanceLeavesOutEmptyBands(t *testing.T) {
+	recs := []*RecordWithDistance{{Dist: 5}, {Dist: 300}}
+	bands := bucketByDistance(recs, []float64{50, 100, 250})
+	if len(bands) != 2 || len(bands["0-50"]) != 1 || len(bands[overflowBand]) != 1 {
+		t.Errorf("bucketByDistance = %v, want only 0-50 and overflow", bands)
+	}
+	if bands := bucketByDistance(recs, nil); len(bands[overflowBand]) != 2 {
+		t.Errorf("without edges: bucketByDistance = %v, want every row in overflow", bands)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var nestedPath = flag.String("nested-path", "location.area", "dot separated path of the geometry field used by the nested command")
+
+const (
+	nestedTable = "geospatial_nested"
+	nestedIndex = "location_area"
+)
+
+// NestedRecordWithDistance is a nearest row of the nested demo. Struct tags
+// are fixed at compile time while the geometry's path is a flag, so the
+// query reads the geometry from that path into Area rather than decoding
+// the document.
+type NestedRecordWithDistance struct {
+	Dist float64     `gorethink:"dist"`
+	Name string      `gorethink:"name"`
+	Area types.Point `gorethink:"area"`
+}
+
+// nestedDoc returns the document of a record named name with its geometry p
+// at path, so location.area gives {"name": name, "location": {"area": p}}.
+func nestedDoc(name string, p types.Point, path string) (map[string]interface{}, error) {
+	fields := strings.Split(path, ".")
+	for _, f := range fields {
+		if f == "" {
+			return nil, fmt.Errorf("invalid nested path %q", path)
+		}
+	}
+	if fields[0] == "name" {
+		return nil, errors.New("the nested path can't be under name, which holds the record's name")
+	}
+	doc := map[string]interface{}{"name": name}
+	parent := doc
+	for _, f := range fields[:len(fields)-1] {
+		child := map[string]interface{}{}
+		parent[f] = child
+		parent = child
+	}
+	parent[fields[len(fields)-1]] = p
+	return doc, nil
+}
+
+// runNestedDemo indexes geometry stored in a nested field and queries it,
+// writing what it prints to out like RunDemo. It stops at the first error.
+func runNestedDemo(session *r.Session, out io.Writer) error {
+	nested := make([]interface{}, len(records))
+	for i, record := range records {
+		doc, err := nestedDoc(record.Name, record.GeoSpatial, *nestedPath)
+		if err != nil {
+			return err
+		}
+		nested[i] = doc
+	}
+
+	fmt.Fprintln(out, "create nested table and index on", *nestedPath)
+	r.DB(DBName).TableDrop(nestedTable).Exec(session)
+	if err := r.DB(DBName).TableCreate(nestedTable).Exec(session); err != nil {
+		return fmt.Errorf("cannot create table: %v", err)
+	}
+	if err := createGeoIndex(session, nestedTable, nestedIndex, *nestedPath); err != nil {
+		return fmt.Errorf("cannot create index: %v", err)
+	}
+	if err := r.DB(DBName).Table(nestedTable).IndexWait(nestedIndex).Exec(session); err != nil {
+		return fmt.Errorf("cannot wait for index: %v", err)
+	}
+
+	fmt.Fprintln(out, "insert nested records")
+	if _, err := r.DB(DBName).Table(nestedTable).Insert(nested).RunWrite(session); err != nil {
+		return fmt.Errorf("cannot create records: %v", err)
+	}
+
+	fmt.Fprintln(out, "Get nearest nested records")
+	var rows []*NestedRecordWithDistance
+	res, err := runQuery(session, nestedNearestQuery(*nestedPath), printingTo(out))
+	if err != nil {
+		return err
+	}
+	if err = res.All(&rows); err != nil {
+		return err
+	}
+	rows = rows[:displayCount(len(rows))]
+	for k := range rows {
+		printStructAsJSON(out, rows[k])
+	}
+	fmt.Fprintln(out, "")
+	return nil
+}
+
+func nestedNearestQuery(path string) r.Term {
+	fields := strings.Split(path, ".")
+	return r.DB(DBName).Table(nestedTable).
+		GetNearest(demoPoint, r.GetNearestOpts{Index: nestedIndex, MaxDist: 100, MaxResults: 1024, Unit: "mi"}).
+		Map(func(row r.Term) interface{} {
+			return map[string]interface{}{
+				"dist": row.Field("dist"),
+				"name": row.Field("doc").Field("name"),
+				"area": fieldPath(row.Field("doc"), fields),
+			}
+		})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+func TestNestedDoc(t *testing.T) {
+	p := types.Point{Lon: 1, Lat: 2}
+	tests := []struct {
+		path string
+		want map[string]interface{}
+	}{
+		{"area", map[string]interface{}{"name": "a", "area": p}},
+		{"location.area", map[string]interface{}{"name": "a", "location": map[string]interface{}{"area": p}}},
+		{"geo.point.value", map[string]interface{}{"name": "a", "geo": map[string]interface{}{"point": map[string]interface{}{"value": p}}}},
+	}
+	for _, tt := range tests {
+		got, err := nestedDoc("a", p, tt.path)
+		if err != nil {
+			t.Fatalf("nestedDoc(%q): %v", tt.path, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nestedDoc(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	for _, path := range []string{"", "location.", ".area", "name.area"} {
+		if _, err := nestedDoc("a", p, path); err == nil {
+			t.Errorf("nestedDoc(%q) returned no error", path)
+		}
+	}
+}
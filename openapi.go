@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+)
+
+// openAPISpec describes the JSON endpoints of the serve command. It is kept
+// by hand, so update it together with the handlers, parseNearestParams and
+// the result types: the schemas mirror how NearestResult, RecordWithDistance
+// and Record encode to JSON, Go field names included. Its paths must be the
+// routes with inSpec set, which openapi_test.go checks.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {"title": "rethink_geo_examples", "version": "1"},
+  "paths": {
+    "/nearest": {
+      "get": {
+        "summary": "Records nearest to a point, closest first",
+        "parameters": [
+          {"name": "lon", "in": "query", "required": true, "schema": {"type": "number", "minimum": -180, "maximum": 180}},
+          {"name": "lat", "in": "query", "required": true, "schema": {"type": "number", "minimum": -90, "maximum": 90}},
+          {"name": "max_dist", "in": "query", "schema": {"type": "number", "exclusiveMinimum": true, "minimum": 0, "default": 100000}},
+          {"name": "max_results", "in": "query", "schema": {"type": "integer", "minimum": 1, "default": 1024}},
+          {"name": "unit", "in": "query", "schema": {"type": "string", "enum": ["m", "km", "mi", "nm", "ft"], "default": "m"}},
+          {"name": "index", "in": "query", "schema": {"type": "string", "default": "area"}},
+          {"name": "page_size", "in": "query", "description": "paginate with this many records per page", "schema": {"type": "integer", "minimum": 1}},
+          {"name": "token", "in": "query", "description": "next token of the previous page; replaces the other parameters", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "nearest records", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NearestResult"}}}},
+          "304": {"description": "not modified since the ETag in If-None-Match"},
+          "400": {"description": "invalid parameters"},
+          "503": {"description": "too many concurrent queries, retry later"}
+        }
+      }
+    },
+    "/nearest/batch": {
+      "post": {
+        "summary": "Several nearest queries at once",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/BatchQuery"}}}}
+        },
+        "responses": {
+          "200": {"description": "one result per query, in order", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/BatchResult"}}}}},
+          "400": {"description": "invalid body"},
+          "503": {"description": "too many concurrent queries, retry later"}
+        }
+      }
+    },
+    "/healthz": {
+      "get": {
+        "summary": "Whether the server is connected to RethinkDB",
+        "responses": {
+          "200": {"description": "connected", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "503": {"description": "not connected"}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Point": {
+        "type": "object",
+        "properties": {"Lon": {"type": "number"}, "Lat": {"type": "number"}}
+      },
+      "Record": {
+        "type": "object",
+        "properties": {
+          "ID": {"type": "string"},
+          "Code": {"type": "string"},
+          "Name": {"type": "string"},
+          "GeoSpatial": {"$ref": "#/components/schemas/Point"},
+          "Region": {"type": "string"},
+          "Category": {"type": "string"},
+          "Layer": {"type": "string"},
+          "Deleted": {"type": "boolean"},
+          "Priority": {"type": "number"},
+          "Elevation": {"type": "number"},
+          "CreatedAt": {"type": "string", "format": "date-time", "nullable": true},
+          "UpdatedAt": {"type": "string", "format": "date-time", "nullable": true}
+        }
+      },
+      "RecordWithDistance": {
+        "type": "object",
+        "properties": {
+          "Dist": {"type": "number", "description": "distance in the result's unit, rounded to -dist-precision"},
+          "Doc": {"$ref": "#/components/schemas/Record"},
+          "Geohash": {"type": "string", "description": "only with -geohash"},
+          "VeryClose": {"type": "boolean"},
+          "Rank": {"type": "integer", "description": "1-based position in the result"},
+          "DistMeters": {"type": "number", "description": "only with -units"},
+          "DistMiles": {"type": "number", "description": "only with -units"},
+          "DistKm": {"type": "number", "description": "only with -units"}
+        }
+      },
+      "NearestResult": {
+        "type": "object",
+        "properties": {
+          "query": {"$ref": "#/components/schemas/Point"},
+          "unit": {"type": "string"},
+          "records": {"type": "array", "items": {"$ref": "#/components/schemas/RecordWithDistance"}},
+          "next": {"type": "string", "description": "token for the next page, paginated queries only"}
+        }
+      },
+      "BatchQuery": {
+        "type": "object",
+        "required": ["lon", "lat"],
+        "properties": {
+          "lon": {"type": "number"},
+          "lat": {"type": "number"},
+          "max_dist": {"type": "number"},
+          "unit": {"type": "string", "enum": ["m", "km", "mi", "nm", "ft"]},
+          "index": {"type": "string", "default": "area"}
+        }
+      },
+      "BatchResult": {
+        "type": "object",
+        "properties": {
+          "results": {"$ref": "#/components/schemas/NearestResult"},
+          "error": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
+
+// handleOpenAPI serves GET /openapi.json.
+func (s *server) handleOpenAPI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOpenAPISpecMatchesRoutes checks that openAPISpec is valid JSON and
+// documents exactly the routes marked inSpec, so a handler added or removed
+// without updating the spec fails here.
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatal("openAPISpec is not valid JSON: ", err)
+	}
+	s := &server{}
+	routed := make(map[string]bool)
+	for _, rt := range s.routes() {
+		routed[rt.path] = true
+		if _, ok := spec.Paths[rt.path]; ok != rt.inSpec {
+			t.Errorf("route %s: in openAPISpec = %v, want %v", rt.path, ok, rt.inSpec)
+		}
+	}
+	for path := range spec.Paths {
+		if !routed[path] {
+			t.Errorf("openAPISpec documents %s, which has no handler", path)
+		}
+	}
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&server{}).handleOpenAPI(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if rec.Body.String() != openAPISpec {
+		t.Error("body is not openAPISpec")
+	}
+}
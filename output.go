@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var distPrecision = flag.Int("dist-precision", 2, "round printed and served distances to this many decimals, negative prints them unrounded")
+
+var snapGrid = flag.Float64("snap", 0, "snap printed points to a grid of this many degrees, 0 prints them as stored")
+
+var outputFormat = flag.String("output", "json", `result format: "json" prints documents as stored, "flat" prints plain name/lat/lon/dist objects, "wkt" prints each point as Well-Known Text`)
+
+// FlatResult is a nearest result without the $reql_type$ GEOMETRY wrapper,
+// for consumers that only want plain coordinates. Dist is nil for a record
+// printed without a distance, so a result at distance 0 still has one.
+type FlatResult struct {
+	Name string   `json:"name"`
+	Lat  float64  `json:"lat"`
+	Lon  float64  `json:"lon"`
+	Dist *float64 `json:"dist,omitempty"`
+
+	Geohash string `json:"geohash,omitempty"`
+}
+
+func flattenResult(row *RecordWithDistance) FlatResult {
+	f := flattenRecord(row.Doc)
+	dist := roundDist(row.Dist)
+	f.Dist = &dist
+	f.Geohash = row.Geohash
+	return f
+}
+
+func flattenRecord(rec *Record) FlatResult {
+	return FlatResult{
+		Name: rec.Name,
+		Lat:  rec.GeoSpatial.Lat,
+		Lon:  rec.GeoSpatial.Lon,
+	}
+}
+
+func validOutputFormat(format string) bool {
+	switch format {
+	case "json", "flat", "wkt":
+		return true
+	}
+	return false
+}
+
+// snapped returns row with its point snapped to the -snap grid, leaving row
+// itself alone. Only the point moves: dist is the distance to the stored,
+// unsnapped point.
+func snapped(row *RecordWithDistance) *RecordWithDistance {
+	if *snapGrid <= 0 || row.Doc == nil {
+		return row
+	}
+	doc := *row.Doc
+	doc.GeoSpatial = snapPoint(doc.GeoSpatial, *snapGrid)
+	return &RecordWithDistance{Dist: row.Dist, Doc: &doc, Geohash: row.Geohash, VeryClose: row.VeryClose, Units: row.Units, Rank: row.Rank}
+}
+
+func printResultWithDistance(w io.Writer, row *RecordWithDistance) {
+	row = snapped(row)
+	switch *outputFormat {
+	case "flat":
+		printStructAsJSON(w, flattenResult(row))
+		return
+	case "wkt":
+		printWKT(w, row.Doc.GeoSpatial)
+		return
+	}
+	printStructAsJSON(w, row)
+}
+
+// printRecord prints a record without a distance; in flat mode dist is left out.
+func printRecord(w io.Writer, rec *Record) {
+	rec = snapped(&RecordWithDistance{Doc: rec}).Doc
+	switch *outputFormat {
+	case "flat":
+		printStructAsJSON(w, flattenRecord(rec))
+		return
+	case "wkt":
+		printWKT(w, rec.GeoSpatial)
+		return
+	}
+	printStructAsJSON(w, rec)
+}
+
+// roundDist rounds a distance for display to -dist-precision decimals, halves
+// rounding up. It rounds the shortest decimal form of d rather than its
+// binary value, so 1.005 becomes 1.01 as it reads, not 1.00 as
+// math.Round(d*100)/100 gives. Only output is rounded; sorting and ranking
+// always see the full distance.
+func roundDist(d float64) float64 {
+	if *distPrecision < 0 || math.IsNaN(d) || math.IsInf(d, 0) {
+		return d
+	}
+	x, ok := new(big.Rat).SetString(strconv.FormatFloat(d, 'g', -1, 64))
+	if !ok {
+		return d
+	}
+	rounded, _ := strconv.ParseFloat(x.FloatString(*distPrecision), 64)
+	return rounded
+}
+
+// printWKT prints p as a WKT line. WKT carries only the geometry, so names
+// and distances are left out.
+func printWKT(w io.Writer, p types.Point) {
+	if _, err := fmt.Fprintln(w, pointToWKT(p)); err != nil {
+		log.Println("Cannot write result: ", err)
+	}
+}
+
+// pointToWKT formats p as WKT, POINT(lon lat). Like GeoJSON, WKT puts the
+// longitude first.
+func pointToWKT(p types.Point) string {
+	return "POINT(" + wktCoords(p) + ")"
+}
+
+// polygonToWKT formats poly, in the types.Lines form described in hull.go, as
+// a WKT POLYGON: the exterior ring first, then the holes, each ring closed.
+func polygonToWKT(poly types.Lines) string {
+	if len(poly) == 0 {
+		return "POLYGON EMPTY"
+	}
+	rings := make([]string, len(poly))
+	for i, ring := range poly {
+		coords := make([]string, len(ring))
+		for j, p := range ring {
+			coords[j] = wktCoords(p)
+		}
+		rings[i] = "(" + strings.Join(coords, ", ") + ")"
+	}
+	return "POLYGON(" + strings.Join(rings, ", ") + ")"
+}
+
+func wktCoords(p types.Point) string {
+	return strconv.FormatFloat(p.Lon, 'f', -1, 64) + " " + strconv.FormatFloat(p.Lat, 'f', -1, 64)
+}
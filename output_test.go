@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+func TestPointToWKT(t *testing.T) {
+	tests := []struct {
+		p    types.Point
+		want string
+	}{
+		{types.Point{Lon: 13.405, Lat: 52.52}, "POINT(13.405 52.52)"},
+		{types.Point{Lon: -122.4194, Lat: -0.5}, "POINT(-122.4194 -0.5)"},
+		{types.Point{Lon: 0, Lat: 0}, "POINT(0 0)"},
+		{types.Point{Lon: 1e-7, Lat: -90}, "POINT(0.0000001 -90)"},
+	}
+	for _, tt := range tests {
+		if got := pointToWKT(tt.p); got != tt.want {
+			t.Errorf("pointToWKT(%+v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestPolygonToWKT(t *testing.T) {
+	ring := types.Line{{Lon: 0, Lat: 0}, {Lon: 10, Lat: 0}, {Lon: 10, Lat: 10}, {Lon: 0, Lat: 10}, {Lon: 0, Lat: 0}}
+	hole := types.Line{{Lon: 2, Lat: 2}, {Lon: 2, Lat: 3.5}, {Lon: 3.5, Lat: 2}, {Lon: 2, Lat: 2}}
+	tests := []struct {
+		poly types.Lines
+		want string
+	}{
+		{types.Lines{ring}, "POLYGON((0 0, 10 0, 10 10, 0 10, 0 0))"},
+		{types.Lines{ring, hole}, "POLYGON((0 0, 10 0, 10 10, 0 10, 0 0), (2 2, 2 3.5, 3.5 2, 2 2))"},
+		{nil, "POLYGON EMPTY"},
+	}
+	for _, tt := range tests {
+		if got := polygonToWKT(tt.poly); got != tt.want {
+			t.Errorf("polygonToWKT(%v) = %q, want %q", tt.poly, got, tt.want)
+		}
+	}
+}
+
+func TestFlatResultDist(t *testing.T) {
+	rec := &Record{Name: "here", GeoSpatial: types.Point{Lon: 1, Lat: 2}}
+	tests := []struct {
+		name string
+		f    FlatResult
+		want string
+	}{
+		{"result at distance 0", flattenResult(&RecordWithDistance{Doc: rec}), `{"name":"here","lat":2,"lon":1,"dist":0}`},
+		{"record", flattenRecord(rec), `{"name":"here","lat":2,"lon":1}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
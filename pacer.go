@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// pacer spaces out operations so at most rate of them run per second, to
+// avoid overwhelming a shared cluster. A zero rate never waits.
+type pacer struct {
+	ticker *time.Ticker
+}
+
+func newPacer(rate float64) *pacer {
+	if rate <= 0 {
+		return &pacer{}
+	}
+	return &pacer{ticker: time.NewTicker(time.Duration(float64(time.Second) / rate))}
+}
+
+// wait blocks until the next operation may run or ctx is done.
+func (p *pacer) wait(ctx context.Context) error {
+	if p.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-p.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *pacer) stop() {
+	if p.ticker != nil {
+		p.ticker.Stop()
+	}
+}
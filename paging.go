@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// pageToken is the state of a paginated nearest query: the query itself and
+// where the previous page ended. It travels to clients as an opaque string,
+// see encodePageToken, so the server keeps no state between pages.
+type pageToken struct {
+	Lon      float64 `json:"lon"`
+	Lat      float64 `json:"lat"`
+	MaxDist  float64 `json:"max_dist"`
+	Unit     string  `json:"unit"`
+	Index    string  `json:"index"`
+	PageSize int     `json:"page_size"`
+
+	// Seen is how many rows earlier pages returned; zero on the first page.
+	Seen     int     `json:"seen,omitempty"`
+	LastDist float64 `json:"last_dist,omitempty"`
+	LastID   string  `json:"last_id,omitempty"`
+}
+
+func newPageToken(p types.Point, opts r.GetNearestOpts, pageSize int) pageToken {
+	t := pageToken{Lon: p.Lon, Lat: p.Lat, Unit: defaultUnit, Index: indexName, PageSize: pageSize}
+	t.MaxDist, _ = opts.MaxDist.(float64)
+	if unit, ok := opts.Unit.(string); ok && unit != "" {
+		t.Unit = unit
+	}
+	if index, ok := opts.Index.(string); ok && index != "" {
+		t.Index = index
+	}
+	return t
+}
+
+func encodePageToken(t pageToken) string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePageToken parses a token from encodePageToken. Tokens come from
+// clients, so the query they carry is validated like URL parameters are.
+func decodePageToken(s string) (pageToken, error) {
+	var t pageToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, errors.New("malformed page token")
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, errors.New("malformed page token")
+	}
+	switch {
+	case !validPoint(types.Point{Lon: t.Lon, Lat: t.Lat}):
+		return t, fmt.Errorf("page token: invalid point lon %v, lat %v", t.Lon, t.Lat)
+	case t.MaxDist <= 0, t.PageSize < 1, t.Seen < 0:
+		return t, errors.New("page token: invalid max_dist, page_size or seen")
+	case !validUnit(t.Unit):
+		return t, fmt.Errorf("page token: invalid unit %q", t.Unit)
+	}
+	return t, nil
+}
+
+// nearestPage returns the page of nearest results t describes and the token
+// for the page after it, empty on the last page. Results are in (dist, id)
+// order and a page starts strictly after the last (dist, id) of the previous
+// one, so records at the same distance are neither repeated nor skipped
+// across a page boundary.
+//
+// GetNearest can't start at an offset, so every page asks the server for at
+// least Seen+PageSize candidates and drops the ones before the keyset
+// position. When there are more records within MaxDist than candidates, the
+// farthest candidates are tied at some distance and GetNearest cuts the tie
+// at an arbitrary record, not by id; a page ending at that distance could
+// then miss a record the next page starts after. So could a page come up
+// short because soft-deleted records took candidate slots. In both cases the
+// page is asked for again with twice the candidates, until it is complete or
+// reaches -max-results-cap candidates, where it is returned as it is.
+//
+// Late pages therefore cost as much as reading everything up to them, and a
+// record inserted closer than the current position after paging started
+// isn't seen. Like pageNearest this needs the default -primary-key. The
+// token's index comes from the client, so it is checked with checkGeoIndex
+// like nearest checks its index.
+func nearestPage(session *r.Session, t pageToken, runOpts ...r.RunOpts) (*NearestResult, string, error) {
+	if err := checkGeoIndex(session, tableName, t.Index); err != nil {
+		return nil, "", err
+	}
+	var page pageCandidates
+	var n int
+	start := time.Now()
+	for n = t.Seen + t.PageSize; ; n *= 2 {
+		if *maxResultsCap > 0 && n > *maxResultsCap {
+			n = *maxResultsCap
+		}
+		page = pageCandidates{}
+		res, err := runQuery(session, nearestPageQuery(t, n), runOpts...)
+		if err != nil {
+			return nil, "", err
+		}
+		if err = res.One(&page); err != nil {
+			return nil, "", err
+		}
+		if page.Rows, err = decodeRows[*RecordWithDistance](page.RawRows); err != nil {
+			return nil, "", err
+		}
+		if page.complete(n, t.PageSize) || n == *maxResultsCap {
+			break
+		}
+	}
+
+	rows := page.Rows
+	// Logged with the candidates the last query asked for, and the time of
+	// every query it took to get there.
+	opts := t.nearestOpts()
+	opts.MaxResults = n
+	logSlowQuery(t.point(), opts, time.Since(start), len(rows))
+	result := newNearestResult(t.point(), t.nearestOpts(), rows)
+	// Ranks carry on from the earlier pages.
+	for _, row := range result.Records {
+		row.Rank += t.Seen
+	}
+	var next string
+	if len(rows) == t.PageSize {
+		last := rows[len(rows)-1]
+		t.Seen += len(rows)
+		t.LastDist, t.LastID = last.Dist, last.Doc.ID
+		next = encodePageToken(t)
+	}
+	return result, next, nil
+}
+
+// pageCandidates is what nearestPageQuery returns: the page, how many
+// candidates GetNearest returned and the distance of the farthest one.
+type pageCandidates struct {
+	Rows       []*RecordWithDistance `gorethink:"-"`
+	Candidates int                   `gorethink:"candidates"`
+	Edge       float64               `gorethink:"edge"`
+
+	// RawRows are the rows as the server sent them, Rows is decoded from
+	// them with decodeRows.
+	RawRows []interface{} `gorethink:"rows"`
+}
+
+// complete reports whether the page can be trusted, the query having asked
+// for n candidates: either GetNearest returned everything within MaxDist, or
+// the page is full and ends closer than the farthest candidate, so no record
+// it should hold was cut off.
+func (c pageCandidates) complete(n, pageSize int) bool {
+	if c.Candidates < n {
+		return true
+	}
+	return len(c.Rows) == pageSize && c.Rows[len(c.Rows)-1].Dist < c.Edge
+}
+
+func (t pageToken) point() types.Point {
+	return types.Point{Lon: t.Lon, Lat: t.Lat}
+}
+
+func (t pageToken) nearestOpts() r.GetNearestOpts {
+	return r.GetNearestOpts{Index: t.Index, MaxDist: t.MaxDist, MaxResults: t.Seen + t.PageSize, Unit: t.Unit}
+}
+
+// nearestPageQuery is the query for the page t describes out of n
+// candidates.
+func nearestPageQuery(t pageToken, n int) r.Term {
+	opts := t.nearestOpts()
+	opts.MaxResults = n
+	return r.Table(tableName).GetNearest(t.point(), opts).Do(func(candidates r.Term) interface{} {
+		// resultNotDeleted can't be used in here: the server refuses r.Row
+		// nested inside another function.
+		rows := candidates.Filter(func(row r.Term) r.Term {
+			return row.Field("doc").Field("deleted").Default(false).Eq(false)
+		})
+		if t.Seen > 0 {
+			rows = rows.Filter(func(row r.Term) r.Term {
+				return row.Field("dist").Gt(t.LastDist).Or(
+					row.Field("dist").Eq(t.LastDist).And(row.Field("doc").Field("id").Gt(t.LastID)))
+			})
+		}
+		return map[string]interface{}{
+			"rows": rows.OrderBy(r.Asc("dist"), r.Asc(func(row r.Term) r.Term {
+				return row.Field("doc").Field("id")
+			})).Limit(t.PageSize),
+			"candidates": candidates.Count(),
+			"edge":       r.Branch(candidates.IsEmpty(), 0, candidates.Nth(-1).Field("dist")),
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPageCandidatesComplete(t *testing.T) {
+	rows := func(dists ...float64) []*RecordWithDistance {
+		var out []*RecordWithDistance
+		for _, d := range dists {
+			out = append(out, &RecordWithDistance{Dist: d})
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		page pageCandidates
+		want bool
+	}{
+		{"all candidates returned", pageCandidates{Rows: rows(1, 2), Candidates: 3, Edge: 2}, true},
+		{"full page before the edge", pageCandidates{Rows: rows(1, 2), Candidates: 4, Edge: 3}, true},
+		{"full page ending on the edge", pageCandidates{Rows: rows(1, 3), Candidates: 4, Edge: 3}, false},
+		{"short page", pageCandidates{Rows: rows(1), Candidates: 4, Edge: 3}, false},
+		{"empty page", pageCandidates{Candidates: 4, Edge: 3}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.page.complete(4, 2); got != tt.want {
+			t.Errorf("%s: complete = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
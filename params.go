@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// Defaults for nearest queries whose parameters don't say otherwise. The
+// distance defaults are RethinkDB's own.
+const (
+	defaultMaxResults = 1024
+	defaultMaxDist    = 100000
+	defaultUnit       = "m"
+)
+
+// validUnit reports whether unit is a distance unit RethinkDB accepts.
+func validUnit(unit string) bool {
+	switch unit {
+	case "m", "km", "mi", "nm", "ft":
+		return true
+	}
+	return false
+}
+
+// parseLatLon parses a point written the way people usually write one,
+// latitude first: "37.779,-122.423", with spaces allowed around either
+// number. A "geo:" URI prefix, as in "geo:37.779,-122.423", is accepted and
+// any ;parameters after the coordinates are ignored; an altitude is not.
+func parseLatLon(s string) (types.Point, error) {
+	in := s
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(s), "geo:"); ok {
+		s, _, _ = strings.Cut(rest, ";")
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 {
+		return types.Point{}, fmt.Errorf("invalid point %q: %d comma separated fields, want lat,lon", in, len(fields))
+	}
+	// ParseFloat takes "NaN" and "Inf" too. An infinity fails the range
+	// checks below, NaN compares false with everything so it is caught here.
+	lat, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	if err != nil || math.IsNaN(lat) {
+		return types.Point{}, fmt.Errorf("invalid point %q: latitude %q is not a number", in, strings.TrimSpace(fields[0]))
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if err != nil || math.IsNaN(lon) {
+		return types.Point{}, fmt.Errorf("invalid point %q: longitude %q is not a number", in, strings.TrimSpace(fields[1]))
+	}
+	if lat < -90 || lat > 90 {
+		return types.Point{}, fmt.Errorf("invalid point %q: latitude %v is out of range, want -90 to 90", in, lat)
+	}
+	if lon < -180 || lon > 180 {
+		return types.Point{}, fmt.Errorf("invalid point %q: longitude %v is out of range, want -180 to 180", in, lon)
+	}
+	return types.Point{Lon: lon, Lat: lat}, nil
+}
+
+// parseNearestParams reads a nearest query from URL parameters: lon and lat
+// are required, max_dist, max_results, unit and index are optional and
+// default to defaultMaxDist, defaultMaxResults, defaultUnit and indexName.
+// A max_results over -max-results-cap is an error.
+func parseNearestParams(v url.Values) (types.Point, r.GetNearestOpts, error) {
+	var p types.Point
+	opts := r.GetNearestOpts{Index: indexName, MaxDist: float64(defaultMaxDist), MaxResults: defaultMaxResults, Unit: defaultUnit}
+
+	var err error
+	if p.Lon, err = strconv.ParseFloat(v.Get("lon"), 64); err != nil || math.IsNaN(p.Lon) || p.Lon < -180 || p.Lon > 180 {
+		return p, opts, fmt.Errorf("invalid lon %q, want a number between -180 and 180", v.Get("lon"))
+	}
+	if p.Lat, err = strconv.ParseFloat(v.Get("lat"), 64); err != nil || math.IsNaN(p.Lat) || p.Lat < -90 || p.Lat > 90 {
+		return p, opts, fmt.Errorf("invalid lat %q, want a number between -90 and 90", v.Get("lat"))
+	}
+	if s := v.Get("max_dist"); s != "" {
+		d, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(d) || math.IsInf(d, 0) || d <= 0 {
+			return p, opts, fmt.Errorf("invalid max_dist %q, want a positive number", s)
+		}
+		opts.MaxDist = d
+	}
+	if s := v.Get("max_results"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, opts, fmt.Errorf("invalid max_results %q, want a positive integer", s)
+		}
+		if *maxResultsCap > 0 && n > *maxResultsCap {
+			return p, opts, fmt.Errorf("max_results %d is over the server's limit of %d", n, *maxResultsCap)
+		}
+		opts.MaxResults = n
+	}
+	if s := v.Get("unit"); s != "" {
+		if !validUnit(s) {
+			return p, opts, fmt.Errorf("invalid unit %q, want one of m, km, mi, nm, ft", s)
+		}
+		opts.Unit = s
+	}
+	if s := v.Get("index"); s != "" {
+		opts.Index = s
+	}
+	return p, opts, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseLatLon(t *testing.T) {
+	p, err := parseLatLon("geo:37.779, -122.423;u=35")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Lat != 37.779 || p.Lon != -122.423 {
+		t.Errorf("got lat %v, lon %v, want 37.779, -122.423", p.Lat, p.Lon)
+	}
+	for _, s := range []string{"NaN,0", "0,NaN", "Inf,0", "0,-Inf", "91,0", "0,181", "37.779", "a,b"} {
+		if _, err := parseLatLon(s); err == nil {
+			t.Errorf("parseLatLon(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParseNearestParamsRejectsNonFinite(t *testing.T) {
+	for _, q := range []string{
+		"lon=NaN&lat=0",
+		"lon=0&lat=NaN",
+		"lon=Inf&lat=0",
+		"lon=0&lat=-Inf",
+		"lon=0&lat=0&max_dist=NaN",
+		"lon=0&lat=0&max_dist=Inf",
+		"lon=0&lat=0&max_dist=+Inf",
+	} {
+		v, _ := url.ParseQuery(q)
+		if _, _, err := parseNearestParams(v); err == nil {
+			t.Errorf("parseNearestParams(%q) succeeded, want an error", q)
+		}
+	}
+	v, _ := url.ParseQuery("lon=-122.4&lat=37.7&max_dist=250")
+	if _, _, err := parseNearestParams(v); err != nil {
+		t.Errorf("parseNearestParams of a valid query: %v", err)
+	}
+}
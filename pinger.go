@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+var pingInterval = flag.Duration("ping-interval", 30*time.Second, "serve: run a trivial query this often to find connections dropped while idle, 0 turns it off")
+
+// pinger runs a trivial query on the session every interval. The driver
+// discards a pooled connection once a query on it fails, so a connection a
+// firewall dropped while idle fails the ping instead of the next request;
+// with -reconnect, runQuery then reopens the session and logs it. One ping
+// only exercises one pooled connection, so this lowers the chance of a
+// request hitting a dead one rather than ruling it out.
+type pinger struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startPinger(session *r.Session, interval time.Duration) *pinger {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pinger{cancel: cancel, done: make(chan struct{})}
+	go p.run(ctx, session, interval)
+	return p
+}
+
+func (p *pinger) run(ctx context.Context, session *r.Session, interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		res, err := runQuery(session, r.Expr(1), r.RunOpts{Context: pingCtx})
+		if err == nil {
+			err = res.Close()
+		}
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Println("Ping failed: ", err)
+		}
+	}
+}
+
+// stop stops the pinger and waits for a ping in flight to finish.
+func (p *pinger) stop() {
+	p.cancel()
+	<-p.done
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// selectivityProbe counts the records within each of radii meters of center,
+// to help pick MaxDist and MaxResults: a radius whose count is far above
+// MaxResults will have GetNearest cut off before MaxDist, one with a count
+// near zero returns little. The counts go through the geo index with
+// GetIntersecting on a circle and run concurrently, one query per radius.
+// Soft-deleted records and region polygons aren't counted, see
+// isPointRecord.
+func selectivityProbe(session *r.Session, center types.Point, radii []float64) (map[float64]int, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	counts := make(map[float64]int, len(radii))
+	for _, radius := range radii {
+		wg.Add(1)
+		go func(radius float64) {
+			defer wg.Done()
+			var n int
+			query := r.Table(tableName).
+				GetIntersecting(r.Circle(center, radius), r.GetIntersectingOpts{Index: indexName}).
+				Filter(notDeleted()).
+				Filter(isPointRecord()).
+				Count()
+			res, err := runQuery(session, query)
+			if err == nil {
+				err = res.One(&n)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			counts[radius] = n
+		}(radius)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return counts, nil
+}
+
+// centroidWithinRadius returns the sphericalCentroid of the records within
+// radiusMeters of center and how many there are, or ErrNoNearby if there are
+// none. Region polygons stored in the same table are left out. Only the ids
+// and points are fetched, but all of them are; for a radius holding a large
+// part of the table, compute the sums server-side instead.
+func centroidWithinRadius(session *r.Session, center types.Point, radiusMeters float64) (types.Point, int, error) {
+	var rows []Record
+	query := r.Table(tableName).
+		GetIntersecting(r.Circle(center, radiusMeters), r.GetIntersectingOpts{Index: indexName}).
+		Filter(notDeleted()).
+		Filter(isPointRecord()).
+		Pluck("id", indexName)
+	res, err := runQuery(session, query)
+	if err != nil {
+		return types.Point{}, 0, err
+	}
+	if err = decodeAll(res, &rows); err != nil {
+		return types.Point{}, 0, err
+	}
+	if len(rows) == 0 {
+		return types.Point{}, 0, ErrNoNearby
+	}
+	points := make([]types.Point, len(rows))
+	for i, row := range rows {
+		points[i] = row.GeoSpatial
+	}
+	return sphericalCentroid(points), len(points), nil
+}
+
+// isPointRecord keeps the documents whose area is a point, leaving out the
+// region polygons insertRegion stores in the same table. GetIntersecting
+// returns both, and a polygon can't be decoded into a Record.
+func isPointRecord() r.Term {
+	return r.Row.Field(indexName).ToGeoJSON().Field("type").Eq("Point")
+}
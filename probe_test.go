@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// insertPointsInRegion inserts two records around center, a point away from
+// the demo data, and a region polygon covering them, and removes them again
+// when t is done.
+func insertPointsInRegion(t *testing.T, session *r.Session) types.Point {
+	t.Helper()
+	defer func(drop bool) { *dropTable = drop }(*dropTable)
+	*dropTable = false
+	if err := createTable(session, io.Discard, *primaryKey, nil); err != nil {
+		t.Fatal("Cannot create table: ", err)
+	}
+	center := types.Point{Lon: 110, Lat: -45}
+	recs := []Record{
+		{ID: "test-west", Name: "west", GeoSpatial: types.Point{Lon: 109.999, Lat: -45}},
+		{ID: "test-east", Name: "east", GeoSpatial: types.Point{Lon: 110.001, Lat: -45}},
+	}
+	if err := r.Table(tableName).Insert(recs, r.InsertOpts{Conflict: "replace"}).Exec(session); err != nil {
+		t.Fatal("Cannot insert records: ", err)
+	}
+	id, err := insertRegion(session, "test region", types.Lines{{
+		{Lon: 109.99, Lat: -45.01}, {Lon: 110.01, Lat: -45.01}, {Lon: 110.01, Lat: -44.99}, {Lon: 109.99, Lat: -44.99}, {Lon: 109.99, Lat: -45.01},
+	}})
+	if err != nil {
+		t.Fatal("Cannot insert region: ", err)
+	}
+	t.Cleanup(func() {
+		r.Table(tableName).GetAll("test-west", "test-east", id).Delete().Exec(session)
+	})
+	return center
+}
+
+func TestCentroidWithinRadiusSkipsRegions(t *testing.T) {
+	session := testSession(t)
+	center := insertPointsInRegion(t, session)
+	got, n, err := centroidWithinRadius(session, center, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("centroidWithinRadius counted %d records, want 2", n)
+	}
+	if !pointEqual(got, center, 1e-6) {
+		t.Errorf("centroidWithinRadius = %+v, want %+v", got, center)
+	}
+}
+
+func TestSelectivityProbeSkipsRegions(t *testing.T) {
+	session := testSession(t)
+	center := insertPointsInRegion(t, session)
+	counts, err := selectivityProbe(session, center, []float64{10, 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for radius, want := range map[float64]int{10: 0, 500: 2} {
+		if counts[radius] != want {
+			t.Errorf("selectivityProbe counted %d records within %vm, want %d", counts[radius], radius, want)
+		}
+	}
+}
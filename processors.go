@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math/rand"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// ResultProcessor transforms nearest results on the client, after the query.
+// Processors are chained with applyProcessors, so each consumer can compose
+// the transforms it needs instead of getting a query function per
+// combination. A processor may modify the rows it is given and return them.
+type ResultProcessor func([]*RecordWithDistance) ([]*RecordWithDistance, error)
+
+// applyProcessors runs procs over rows in order, stopping at the first error,
+// and ranks the rows left with setRanks.
+func applyProcessors(rows []*RecordWithDistance, procs ...ResultProcessor) ([]*RecordWithDistance, error) {
+	for _, proc := range procs {
+		var err error
+		if rows, err = proc(rows); err != nil {
+			return nil, err
+		}
+	}
+	setRanks(rows)
+	return rows, nil
+}
+
+// nearestWith is nearest on index with procs applied to the rows.
+func nearestWith(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, procs []ResultProcessor, runOpts ...r.RunOpts) (*NearestResult, error) {
+	res, err := nearest(session, index, p, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if res.Records, err = applyProcessors(res.Records, procs...); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// dedupeResults keeps the first row of each record id, which for rows in
+// distance order is the closest.
+func dedupeResults() ResultProcessor {
+	return func(rows []*RecordWithDistance) ([]*RecordWithDistance, error) {
+		seen := make(map[string]bool, len(rows))
+		out := rows[:0]
+		for _, row := range rows {
+			if seen[row.Doc.ID] {
+				continue
+			}
+			seen[row.Doc.ID] = true
+			out = append(out, row)
+		}
+		return out, nil
+	}
+}
+
+// filterResults keeps the rows keep returns true for.
+func filterResults(keep func(*RecordWithDistance) bool) ResultProcessor {
+	return func(rows []*RecordWithDistance) ([]*RecordWithDistance, error) {
+		out := rows[:0]
+		for _, row := range rows {
+			if keep(row) {
+				out = append(out, row)
+			}
+		}
+		return out, nil
+	}
+}
+
+// roundResults rounds every point with roundPoint. Distances are left alone.
+func roundResults(decimals int) ResultProcessor {
+	return func(rows []*RecordWithDistance) ([]*RecordWithDistance, error) {
+		for _, row := range rows {
+			row.Doc.GeoSpatial = roundPoint(row.Doc.GeoSpatial, decimals)
+		}
+		return rows, nil
+	}
+}
+
+// jitterResults moves every point with jitterPoint. Distances are left alone,
+// so they give away roughly where the true point is; drop them if that
+// matters.
+func jitterResults(maxMeters float64, rng *rand.Rand) ResultProcessor {
+	return func(rows []*RecordWithDistance) ([]*RecordWithDistance, error) {
+		for _, row := range rows {
+			row.Doc.GeoSpatial = jitterPoint(row.Doc.GeoSpatial, maxMeters, rng)
+		}
+		return rows, nil
+	}
+}
+
+// rankResults re-sorts the rows with rankWeighted.
+func rankResults(score scoreFunc) ResultProcessor {
+	return func(rows []*RecordWithDistance) ([]*RecordWithDistance, error) {
+		rankWeighted(rows, score)
+		return rows, nil
+	}
+}
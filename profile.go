@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var profile = flag.Bool("profile", false, "run the demo's queries with profiling and print where the time was spent")
+
+// printProfile prints the profile RethinkDB returns with RunOpts{Profile: true}
+// as an indented tree of task descriptions and durations. The raw profile is
+// a list of tasks, each with a description, a duration in milliseconds and
+// optional sub_tasks, and entries holding parallel_tasks, a list of task lists
+// that ran concurrently.
+func printProfile(w io.Writer, p interface{}) {
+	fmt.Fprintln(w, "query profile:")
+	printProfileTasks(w, p, 1)
+}
+
+func printProfileTasks(w io.Writer, tasks interface{}, depth int) {
+	list, _ := tasks.([]interface{})
+	indent := strings.Repeat("  ", depth)
+	for _, t := range list {
+		task, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if parallel, ok := task["parallel_tasks"].([]interface{}); ok {
+			fmt.Fprintf(w, "%sin parallel:\n", indent)
+			for _, branch := range parallel {
+				printProfileTasks(w, branch, depth+1)
+			}
+			continue
+		}
+		desc, _ := task["description"].(string)
+		ms, _ := task["duration(ms)"].(float64)
+		fmt.Fprintf(w, "%s%.3fms  %s\n", indent, ms, desc)
+		printProfileTasks(w, task["sub_tasks"], depth+1)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types used by nearest.proto.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// resultsToProto encodes rows as a NearestResults message, see
+// nearest.proto. The message is small enough that it is encoded by hand
+// rather than pulling in the protobuf runtime and generated code; the bytes
+// are what protoc-generated code produces, zero fields left out as proto3
+// does.
+func resultsToProto(rows []*RecordWithDistance) ([]byte, error) {
+	var out []byte
+	for _, row := range rows {
+		if row.Doc == nil {
+			return nil, errors.New("result without a document")
+		}
+		var msg []byte
+		if row.Doc.Name != "" {
+			msg = protoTag(msg, 1, wireBytes)
+			msg = binary.AppendUvarint(msg, uint64(len(row.Doc.Name)))
+			msg = append(msg, row.Doc.Name...)
+		}
+		msg = protoDouble(msg, 2, row.Doc.GeoSpatial.Lon)
+		msg = protoDouble(msg, 3, row.Doc.GeoSpatial.Lat)
+		msg = protoDouble(msg, 4, row.Dist)
+
+		out = protoTag(out, 1, wireBytes)
+		out = binary.AppendUvarint(out, uint64(len(msg)))
+		out = append(out, msg...)
+	}
+	return out, nil
+}
+
+// protoToResults decodes a NearestResults message. Only name, lon, lat and
+// dist survive the round trip; unknown fields are skipped, so messages from
+// a newer nearest.proto still decode.
+func protoToResults(b []byte) ([]*RecordWithDistance, error) {
+	var rows []*RecordWithDistance
+	err := protoFields(b, func(field int, wire int, v uint64, data []byte) error {
+		if field != 1 || wire != wireBytes {
+			return nil
+		}
+		row := &RecordWithDistance{Doc: &Record{}}
+		err := protoFields(data, func(field int, wire int, v uint64, data []byte) error {
+			switch {
+			case field == 1 && wire == wireBytes:
+				row.Doc.Name = string(data)
+			case field == 2 && wire == wireFixed64:
+				row.Doc.GeoSpatial.Lon = math.Float64frombits(v)
+			case field == 3 && wire == wireFixed64:
+				row.Doc.GeoSpatial.Lat = math.Float64frombits(v)
+			case field == 4 && wire == wireFixed64:
+				row.Dist = math.Float64frombits(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+func protoTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func protoDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protoTag(b, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// protoFields calls fn for every field of the message in b with its number
+// and wire type, and either its integer value (varint and fixed) or its
+// bytes (length-delimited).
+func protoFields(b []byte, fn func(field, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("proto: bad field tag")
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var (
+			v    uint64
+			data []byte
+		)
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("proto: bad varint")
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errors.New("proto: truncated fixed64")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errors.New("proto: truncated fixed32")
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errors.New("proto: truncated length-delimited field")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("proto: unsupported wire type %d", wire)
+		}
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
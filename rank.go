@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// scoreFunc scores a nearest result for rankWeighted, lower ranks first.
+type scoreFunc func(row *RecordWithDistance) float64
+
+// priorityScore divides the distance by the record's priority, so a record
+// with priority 2 ranks like one at half its distance. Records without a
+// priority count as priority 1.
+func priorityScore(row *RecordWithDistance) float64 {
+	priority := row.Doc.Priority
+	if priority <= 0 {
+		priority = 1
+	}
+	return row.Dist / priority
+}
+
+// rankWeighted sorts rows in place by score, lowest first, and ranks them in
+// that order. Rows with equal scores keep their distance order.
+func rankWeighted(rows []*RecordWithDistance, score scoreFunc) {
+	scores := make(map[*RecordWithDistance]float64, len(rows))
+	for _, row := range rows {
+		scores[row] = score(row)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return scores[rows[i]] < scores[rows[j]] })
+	setRanks(rows)
+}
+
+// rank3D re-sorts nearest results by 3D distance from a query point at
+// queryElev meters: the horizontal distance, scaled by horizWeight, combined
+// with the elevation difference. Dist must be in meters. Elevation is stored
+// alongside the geometry, the geo index stays 2D, so GetNearest still picks
+// the candidates on horizontal distance alone; ask for enough of them that
+// a record far above or below can't crowd out a closer one.
+func rank3D(results []*RecordWithDistance, queryElev, horizWeight float64) {
+	rankWeighted(results, func(row *RecordWithDistance) float64 {
+		return math.Hypot(horizWeight*row.Dist, row.Doc.Elevation-queryElev)
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRankWeightedPriority(t *testing.T) {
+	rows := []*RecordWithDistance{
+		{Dist: 100, Doc: &Record{Name: "close"}},
+		{Dist: 150, Doc: &Record{Name: "far, priority 2", Priority: 2}},
+		{Dist: 300, Doc: &Record{Name: "farthest"}},
+		{Dist: 400, Doc: &Record{Name: "farthest, priority 4", Priority: 4}},
+	}
+	rankWeighted(rows, priorityScore)
+
+	// Scores are 100, 75, 300 and 100: the ties keep their distance order.
+	want := []string{"far, priority 2", "close", "farthest, priority 4", "farthest"}
+	for i, row := range rows {
+		if row.Doc.Name != want[i] {
+			t.Errorf("rows[%d] = %q, want %q", i, row.Doc.Name, want[i])
+		}
+		if row.Rank != i+1 {
+			t.Errorf("rows[%d].Rank = %d, want %d", i, row.Rank, i+1)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// Region is a polygon document stored in the same table as the point records.
+// Point records belong to a region through their region field.
+type Region struct {
+	ID   string `gorethink:"id"`
+	Name string `gorethink:"name"`
+}
+
+// nearestInRegion first finds the regions whose polygon contains p, then
+// finds the nearest point records tagged with one of those regions. Both
+// queries use the geo index named index, which holds the region polygons as
+// well as the points.
+//
+// These are two separate queries and RethinkDB gives no consistency between
+// them: a region or a record can be inserted, moved or deleted in between, so
+// the result reflects the regions of the first query and the records of the
+// second. If p is in no region the second query is skipped and nil is returned.
+func nearestInRegion(session *r.Session, index string, p types.Point, opts r.GetNearestOpts) (*NearestResult, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	regionIDs, err := containingRegions(session, index, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(regionIDs) == 0 {
+		return newNearestResult(p, opts, nil), nil
+	}
+
+	query := nearestQuery(tableName, p, opts).
+		// Not r.Row: inside Contains it would be each region id.
+		Filter(func(row r.Term) r.Term {
+			return r.Expr(regionIDs).Contains(row.Field("doc").Field("region"))
+		})
+	rows, err := runNearest[*RecordWithDistance](session, query, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newNearestResult(p, opts, rows), nil
+}
+
+// nearestWithinPolygon returns the nearest records to p that lie inside mask,
+// a polygon in the types.Lines form described in hull.go, closest first.
+//
+// It is one query: GetNearest on the geo index, then a server-side Filter
+// keeping the rows whose area intersects mask. Only the GetNearest half uses
+// the index; the mask is checked on each candidate GetNearest returns, so
+// MaxResults and MaxDist bound the candidates before the mask does. With a
+// mask far from p, or small next to MaxDist, most candidates are thrown away
+// and the result can come back short or empty even though records inside
+// mask exist; raise MaxResults, or for a small mask go the other way round
+// with GetIntersecting(mask) on the index and distances computed afterwards.
+func nearestWithinPolygon(session *r.Session, index string, p types.Point, mask types.Lines, opts r.GetNearestOpts) ([]*RecordWithDistance, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	query := nearestQuery(tableName, p, opts).
+		Filter(r.Row.Field("doc").Field(indexName).Intersects(types.Geometry{Type: "Polygon", Lines: mask}))
+	rows, err := runNearest[*RecordWithDistance](session, query, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newNearestResult(p, opts, rows).Records, nil
+}
+
+// containingRegions returns the ids of the polygon documents that contain p,
+// found through the geo index named index.
+func containingRegions(session *r.Session, index string, p types.Point) ([]string, error) {
+	var regions []Region
+	res, err := runQuery(session, r.Table(tableName).
+		GetIntersecting(p, r.GetIntersectingOpts{Index: index}).
+		Filter(r.Row.Field("area").ToGeoJSON().Field("type").Eq("Polygon")).
+		Pluck("id", "name"))
+	if err != nil {
+		return nil, err
+	}
+	if err = res.All(&regions); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(regions))
+	for i := range regions {
+		ids[i] = regions[i].ID
+	}
+	return ids, nil
+}
+
+// insertRegion stores poly as a region named name and returns its id. A
+// polygon crossing the antimeridian is stored anyway but logged as a warning,
+// see crossesAntimeridian.
+func insertRegion(session *r.Session, name string, poly types.Lines) (string, error) {
+	if crossesAntimeridian(poly) {
+		log.Printf("Warning: region %q crosses the antimeridian, check it is meant the short way round", name)
+	}
+	resp, err := r.Table(tableName).Insert(map[string]interface{}{
+		"name": name,
+		"area": types.Geometry{Type: "Polygon", Lines: poly},
+	}).RunWrite(session)
+	stats.observeInsert(resp.Inserted, err)
+	var id string
+	if len(resp.GeneratedKeys) > 0 {
+		id = resp.GeneratedKeys[0]
+	}
+	logWrite("insert", id, err)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	p "gopkg.in/gorethink/gorethink.v3/ql2"
+)
+
+var printQuery = flag.Bool("print-query", false, "print the demo's queries as JavaScript ReQL, for pasting into the admin console's Data Explorer, before running them")
+
+type queryOutputKey struct{}
+
+// withQueryOutput returns a copy of ctx carrying out, which runQuery prints a
+// query run with that context to when -print-query is set. Only the queries
+// a caller wants shown are run with it; the rest, such as the table and index
+// lookups and the pinger's pings, print nothing.
+func withQueryOutput(ctx context.Context, out io.Writer) context.Context {
+	return context.WithValue(ctx, queryOutputKey{}, out)
+}
+
+// queryOutput returns the writer opts.Context carries, see withQueryOutput,
+// or nil.
+func queryOutput(opts r.RunOpts) io.Writer {
+	if opts.Context == nil {
+		return nil
+	}
+	out, _ := opts.Context.Value(queryOutputKey{}).(io.Writer)
+	return out
+}
+
+// printingTo returns RunOpts that print the query they run with to out, for
+// the demo steps.
+func printingTo(out io.Writer) r.RunOpts {
+	return r.RunOpts{Context: withQueryOutput(context.Background(), out)}
+}
+
+// reqlTopLevel are the terms written as r.name(...) rather than as a method
+// on their first argument.
+var reqlTopLevel = map[p.Term_TermType]bool{
+	p.Term_DB:         true,
+	p.Term_POINT:      true,
+	p.Term_LINE:       true,
+	p.Term_POLYGON:    true,
+	p.Term_CIRCLE:     true,
+	p.Term_GEOJSON:    true,
+	p.Term_BRANCH:     true,
+	p.Term_NOW:        true,
+	p.Term_ASC:        true,
+	p.Term_DESC:       true,
+	p.Term_ARGS:       true,
+	p.Term_JAVASCRIPT: true,
+	p.Term_UUID:       true,
+	p.Term_RANGE:      true,
+	p.Term_ERROR:      true,
+}
+
+// reqlString writes query as JavaScript ReQL, the dialect of the JS driver
+// and the Data Explorer. Term.String is no use for that: it prints the Go
+// method names and optional arguments as key=value. Instead the query is
+// built into its wire form, the nested [type, args, optargs] arrays the
+// server runs, and each term is named after its protocol name, GET_NEAREST
+// becoming getNearest; that is how the JavaScript API is named too, so the
+// output runs as the very query sent. Optional arguments keep their wire
+// names, such as max_dist, which the JS driver passes through unchanged.
+// Functions come out as function(var_1) { return ...; } with the driver's
+// variable numbering, and Do as r.do(args..., function) the way the JS
+// driver takes it, or term.do(function) when there are no other arguments.
+func reqlString(query r.Term) (string, error) {
+	built, err := query.Build()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	writeReQL(&b, built)
+	return b.String(), nil
+}
+
+func writeReQL(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		writeReQLTerm(b, v)
+	case map[string]interface{}:
+		writeReQLObject(b, v)
+	default:
+		// Datums: strings, numbers, booleans and null read the same in JS.
+		js, err := json.Marshal(v)
+		if err != nil {
+			fmt.Fprintf(b, "%v", v)
+			return
+		}
+		b.Write(js)
+	}
+}
+
+func writeReQLObject(b *strings.Builder, obj map[string]interface{}) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		js, _ := json.Marshal(k)
+		b.Write(js)
+		b.WriteString(": ")
+		writeReQL(b, obj[k])
+	}
+	b.WriteString("}")
+}
+
+// writeReQLTerm writes one built term, [type], [type, args], [type, optargs]
+// or [type, args, optargs].
+func writeReQLTerm(b *strings.Builder, term []interface{}) {
+	typ, args, optArgs := splitBuiltTerm(term)
+	switch typ {
+	case p.Term_MAKE_ARRAY:
+		b.WriteString("[")
+		writeReQLArgs(b, args, nil)
+		b.WriteString("]")
+		return
+	case p.Term_IMPLICIT_VAR:
+		b.WriteString("r.row")
+		return
+	case p.Term_VAR:
+		b.WriteString("var_")
+		writeReQL(b, args[0])
+		return
+	case p.Term_MINVAL:
+		b.WriteString("r.minval")
+		return
+	case p.Term_MAXVAL:
+		b.WriteString("r.maxval")
+		return
+	case p.Term_FUNC:
+		b.WriteString("function(")
+		_, params, _ := splitBuiltTerm(args[0].([]interface{}))
+		for i, param := range params {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("var_")
+			writeReQL(b, param)
+		}
+		b.WriteString(") { return ")
+		writeReQL(b, args[1])
+		b.WriteString("; }")
+		return
+	case p.Term_FUNCALL:
+		// Built as [func, receiver, more args...].
+		if len(args) == 2 {
+			writeReQL(b, args[1])
+			b.WriteString(".do(")
+			writeReQL(b, args[0])
+			b.WriteString(")")
+			return
+		}
+		b.WriteString("r.do(")
+		writeReQLArgs(b, append(args[1:len(args):len(args)], args[0]), nil)
+		b.WriteString(")")
+		return
+	case p.Term_GET_FIELD, p.Term_BRACKET:
+		writeReQL(b, args[0])
+		b.WriteString("(")
+		writeReQL(b, args[1])
+		b.WriteString(")")
+		return
+	}
+
+	name := reqlName(typ)
+	if reqlTopLevel[typ] || (typ == p.Term_TABLE && len(args) == 1) || len(args) == 0 {
+		b.WriteString("r." + name + "(")
+		writeReQLArgs(b, args, optArgs)
+		b.WriteString(")")
+		return
+	}
+	writeReQL(b, args[0])
+	b.WriteString("." + name + "(")
+	writeReQLArgs(b, args[1:], optArgs)
+	b.WriteString(")")
+}
+
+func writeReQLArgs(b *strings.Builder, args []interface{}, optArgs map[string]interface{}) {
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeReQL(b, arg)
+	}
+	if len(optArgs) > 0 {
+		if len(args) > 0 {
+			b.WriteString(", ")
+		}
+		writeReQLObject(b, optArgs)
+	}
+}
+
+func splitBuiltTerm(term []interface{}) (typ p.Term_TermType, args []interface{}, optArgs map[string]interface{}) {
+	// Build gives the type as an int; the other cases are for a term that
+	// went through JSON or was built by hand.
+	switch n := term[0].(type) {
+	case int:
+		typ = p.Term_TermType(n)
+	case int32:
+		typ = p.Term_TermType(n)
+	case int64:
+		typ = p.Term_TermType(n)
+	case float64:
+		typ = p.Term_TermType(n)
+	case p.Term_TermType:
+		typ = n
+	}
+	for _, part := range term[1:] {
+		switch part := part.(type) {
+		case []interface{}:
+			args = part
+		case map[string]interface{}:
+			optArgs = part
+		}
+	}
+	return typ, args, optArgs
+}
+
+// reqlName turns a protocol term name such as GET_NEAREST into its
+// JavaScript name, getNearest.
+func reqlName(typ p.Term_TermType) string {
+	words := strings.Split(strings.ToLower(p.Term_TermType_name[int32(typ)]), "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "")
+}
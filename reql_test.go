@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// varIDs matches the driver's function variables, which are numbered from a
+// counter shared by every query built in the process.
+var varIDs = regexp.MustCompile(`var_\d+`)
+
+func TestReQLString(t *testing.T) {
+	p := types.Point{Lon: -122.4, Lat: 37.7}
+	opts := r.GetNearestOpts{Index: indexName, MaxDist: 250, MaxResults: 10, Unit: "mi"}
+	const (
+		point     = `{"$reql_type$": "GEOMETRY", "coordinates": [-122.4, 37.7], "type": "Point"}`
+		nearestJS = `r.table("geospatial").getNearest(` + point + `, {"index": "area", "max_dist": 250, "max_results": 10, "unit": "mi"})` +
+			`.filter(function(var_N) { return r.row("doc")("deleted").default(false).eq(false); })`
+	)
+	tests := []struct {
+		name  string
+		query r.Term
+		want  string
+	}{{
+		name:  "nearest",
+		query: nearestQuery(tableName, p, opts),
+		want:  nearestJS,
+	}, {
+		name:  "nearestGeoJSON",
+		query: nearestGeoJSONQuery(p, opts),
+		want: nearestJS + `.map(function(var_N) { return {"dist": var_N("dist"), ` +
+			`"geometry": var_N("doc")("area").toGeojson().toJsonString(), "name": var_N("doc")("name")}; })`,
+	}, {
+		name:  "nearestInLayer",
+		query: nearestInLayerQuery("hotels", "area", p, opts),
+		want: `r.table("geospatial").getAll("hotels", {"index": "layer"})` +
+			`.filter(function(var_N) { return r.row("deleted").default(false).eq(false); })` +
+			`.map(function(var_N) { return {"dist": var_N("area").distance(` + point + `, {"unit": "mi"}), "doc": var_N}; })` +
+			`.filter(function(var_N) { return r.row("dist").le(250); })` +
+			`.orderBy("dist").limit(10)`,
+	}, {
+		name:  "nearestInLayer nested path",
+		query: nearestInLayerQuery("hotels", "location.area", p, opts),
+		want: `r.table("geospatial").getAll("hotels", {"index": "layer"})` +
+			`.filter(function(var_N) { return r.row("deleted").default(false).eq(false); })` +
+			`.map(function(var_N) { return {"dist": var_N("location")("area").distance(` + point + `, {"unit": "mi"}), "doc": var_N}; })` +
+			`.filter(function(var_N) { return r.row("dist").le(250); })` +
+			`.orderBy("dist").limit(10)`,
+	}, {
+		name:  "nearestFlaggingClose",
+		query: nearestFlaggingCloseQuery(p, opts, 1),
+		want:  nearestJS + `.merge(function(var_N) { return r.branch(var_N("dist").le(1), {"very_close": true}, {"very_close": false}); })`,
+	}, {
+		name:  "nearestStable",
+		query: nearestStableQuery(p, opts),
+		want:  nearestJS + `.orderBy(r.asc("dist"), r.asc(function(var_N) { return var_N("doc")("id"); }))`,
+	}, {
+		name:  "nearestLive",
+		query: nearestLiveQuery(p, opts, 3),
+		want: `r.table("geospatial").getNearest(` + point + `, {"index": "area", "max_dist": 250, "max_results": 10, "unit": "mi"})` +
+			`.do(function(var_N) { return {"candidates": var_N.count(), ` +
+			`"rows": var_N.filter(function(var_N) { return var_N("doc")("deleted").default(false).eq(false); }).limit(3)}; })`,
+	}, {
+		name:  "nearestExcluding",
+		query: nearestExcludingQuery(p, opts, []string{"a", "b"}),
+		want: `r.table("geospatial").getNearest(` + point + `, {"index": "area", "max_dist": 250, "max_results": 10, "unit": "mi"})` +
+			`.do(function(var_N) { return var_N("doc"); })` +
+			`.filter(function(var_N) { return r.row("deleted").default(false).eq(false); })` +
+			`.filter(function(var_N) { return ["a", "b"].contains(var_N("id")).not(); })`,
+	}, {
+		name: "nearestPage",
+		query: nearestPageQuery(pageToken{Lon: p.Lon, Lat: p.Lat, MaxDist: 250, Unit: "mi", Index: indexName, PageSize: 5,
+			Seen: 5, LastDist: 1.5, LastID: "x"}, 10),
+		want: `r.table("geospatial").getNearest(` + point + `, {"index": "area", "max_dist": 250, "max_results": 10, "unit": "mi"})` +
+			`.do(function(var_N) { return {"candidates": var_N.count(), "edge": r.branch(var_N.isEmpty(), 0, var_N.nth(-1)("dist")), ` +
+			`"rows": var_N.filter(function(var_N) { return var_N("doc")("deleted").default(false).eq(false); })` +
+			`.filter(function(var_N) { return var_N("dist").gt(1.5).or(var_N("dist").eq(1.5).and(var_N("doc")("id").gt("x"))); })` +
+			`.orderBy(r.asc("dist"), r.asc(function(var_N) { return var_N("doc")("id"); })).limit(5)}; })`,
+	}, {
+		name:  "do with arguments",
+		query: r.Do(r.Table(tableName), 1, func(table, n r.Term) r.Term { return table.Limit(n) }),
+		want:  `r.do(r.table("geospatial"), 1, function(var_N, var_N) { return var_N.limit(var_N); })`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := reqlString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := varIDs.ReplaceAllString(js, "var_N"); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+var (
+	httpAddr     = flag.String("addr", ":8080", "listen address for the serve command")
+	batchWorkers = flag.Int("batch-workers", 4, "number of queries /nearest/batch runs concurrently")
+
+	maxResultsCap = flag.Int("max-results-cap", 10000, "serve: reject requests for more than this many results, max_results or across pages, with a 400; 0 is unlimited")
+	maxBatchSize  = flag.Int("max-batch", 100, "serve: reject /nearest/batch requests with more than this many queries with a 400; 0 is unlimited")
+)
+
+// BatchQuery is one query point of a POST /nearest/batch request.
+type BatchQuery struct {
+	Lon     float64 `json:"lon"`
+	Lat     float64 `json:"lat"`
+	MaxDist float64 `json:"max_dist"`
+	Unit    string  `json:"unit"`
+	Index   string  `json:"index,omitempty"`
+}
+
+// BatchResult is the answer to the BatchQuery at the same position. Error is
+// set instead of Results when that query failed.
+type BatchResult struct {
+	Results *NearestResult `json:"results,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type server struct {
+	session *r.Session
+
+	// sem caps the queries running at once, nil without -max-queries.
+	sem *weightedSem
+}
+
+func serve(session *r.Session, addr string) error {
+	s := &server{session: session}
+	if *maxQueries > 0 {
+		s.sem = newWeightedSem(*maxQueries)
+	}
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.path, rt.handler)
+	}
+	if *warmup {
+		points, err := parseWarmupPoints(*warmupPoints)
+		if err != nil {
+			return err
+		}
+		warmUp(session, points)
+	}
+	if *pingInterval > 0 {
+		p := startPinger(session, *pingInterval)
+		defer p.stop()
+	}
+	log.Println("Listening on ", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// route is an endpoint of the serve command. Routes with inSpec set are the
+// JSON endpoints described in openAPISpec.
+type route struct {
+	path    string
+	handler http.HandlerFunc
+	inSpec  bool
+}
+
+func (s *server) routes() []route {
+	return []route{
+		{"/healthz", s.handleHealthz, true},
+		{"/nearest", s.handleNearest, true},
+		{"/nearest/batch", s.handleNearestBatch, true},
+		{"/nearest.csv", s.handleNearestCSV, false},
+		{"/metrics", s.handleMetrics, false},
+		{"/openapi.json", s.handleOpenAPI, false},
+	}
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	if !s.session.IsConnected() {
+		http.Error(w, "not connected", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// handleNearest serves GET /nearest, see parseNearestParams for the parameters.
+// With page_size the results are paginated: the response carries a next
+// token, and passing it back as token, without the other parameters, gets the
+// following page.
+func (s *server) handleNearest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	if q.Get("token") != "" || q.Get("page_size") != "" {
+		s.handleNearestPage(w, req)
+		return
+	}
+	p, opts, err := parseNearestParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, release, ok := s.admit(w, req, 1)
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	index, _ := opts.Index.(string)
+	res, err := nearest(s.session, index, p, opts, requestRunOpts(req))
+	stats.observeQuery("nearest", time.Since(start), err)
+	if err != nil {
+		queryFailed(w, req, err)
+		return
+	}
+	writeJSONCached(w, req, res)
+}
+
+func (s *server) handleNearestPage(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	var t pageToken
+	if tok := q.Get("token"); tok != "" {
+		var err error
+		if t, err = decodePageToken(tok); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		p, opts, err := parseNearestParams(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := strconv.Atoi(q.Get("page_size"))
+		if err != nil || n < 1 {
+			http.Error(w, "invalid page_size "+strconv.Quote(q.Get("page_size"))+", want a positive integer", http.StatusBadRequest)
+			return
+		}
+		t = newPageToken(p, opts, n)
+	}
+	// Every page queries MaxResults Seen+PageSize, so the cap bounds how
+	// deep paging goes too.
+	if *maxResultsCap > 0 && t.Seen+t.PageSize > *maxResultsCap {
+		http.Error(w, fmt.Sprintf("page goes past result %d, the server's limit is %d results", t.Seen+t.PageSize, *maxResultsCap), http.StatusBadRequest)
+		return
+	}
+
+	_, release, ok := s.admit(w, req, 1)
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	res, next, err := nearestPage(s.session, t, requestRunOpts(req))
+	stats.observeQuery("nearest", time.Since(start), err)
+	if err != nil {
+		queryFailed(w, req, err)
+		return
+	}
+	res.Next = next
+	writeJSONCached(w, req, res)
+}
+
+// handleNearestCSV serves GET /nearest.csv, taking the same parameters as
+// /nearest and answering with name, lat, lon, dist rows. Rows are read from
+// the cursor one at a time and written as they come, so memory stays flat
+// however many results there are. Once streaming has started an error can't
+// change the status any more; the response is cut short and the error logged.
+func (s *server) handleNearestCSV(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, opts, err := parseNearestParams(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, release, ok := s.admit(w, req, 1)
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	index, _ := opts.Index.(string)
+	var res *r.Cursor
+	err = checkGeoIndex(s.session, tableName, index)
+	if err == nil {
+		res, err = runQuery(s.session, nearestQuery(tableName, p, opts), requestRunOpts(req))
+	}
+	if err != nil {
+		stats.observeQuery("nearest_csv", time.Since(start), err)
+		queryFailed(w, req, err)
+		return
+	}
+	defer res.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="nearest.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "lat", "lon", "dist"})
+	var row RecordWithDistance
+	n := 0
+	for {
+		var ok bool
+		if ok, err = nextRow(res, &row); !ok {
+			break
+		}
+		n++
+		cw.Write([]string{
+			row.Doc.Name,
+			strconv.FormatFloat(row.Doc.GeoSpatial.Lat, 'f', -1, 64),
+			strconv.FormatFloat(row.Doc.GeoSpatial.Lon, 'f', -1, 64),
+			strconv.FormatFloat(roundDist(row.Dist), 'f', -1, 64),
+		})
+		row = RecordWithDistance{}
+	}
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	stats.observeQuery("nearest_csv", time.Since(start), err)
+	logSlowQuery(p, opts, time.Since(start), n)
+	if err != nil {
+		log.Println("Cannot stream CSV: ", err)
+	}
+}
+
+// handleNearestBatch serves POST /nearest/batch. The body is a JSON array of
+// BatchQuery and the response is an array of BatchResult in the same order.
+// At most -batch-workers queries run at the same time, and they count
+// against -max-queries like any others.
+func (s *server) handleNearestBatch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var queries []BatchQuery
+	if err := json.NewDecoder(req.Body).Decode(&queries); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if *maxBatchSize > 0 && len(queries) > *maxBatchSize {
+		http.Error(w, fmt.Sprintf("%d queries in the batch, the server's limit is %d", len(queries), *maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	workers := *batchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(queries) {
+		workers = len(queries)
+	}
+	// The batch takes a query slot per worker up front and runs with as many
+	// workers as it got.
+	workers, release, ok := s.admit(w, req, workers)
+	if !ok {
+		return
+	}
+	defer release()
+	out := make([]BatchResult, len(queries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				p, opts, err := parseNearestParams(queries[k].params())
+				if err != nil {
+					out[k].Error = err.Error()
+					continue
+				}
+				start := time.Now()
+				index, _ := opts.Index.(string)
+				res, err := nearest(s.session, index, p, opts, requestRunOpts(req))
+				stats.observeQuery("nearest_batch", time.Since(start), err)
+				if err != nil {
+					out[k].Error = err.Error()
+					continue
+				}
+				out[k].Results = res
+			}
+		}()
+	}
+	for k := range queries {
+		jobs <- k
+	}
+	close(jobs)
+	wg.Wait()
+	writeJSON(w, out)
+}
+
+// requestRunOpts runs a query under the request's context, so a client that
+// disconnects, or a server shutting down, cancels the RethinkDB query
+// instead of leaving it to run to completion for nobody.
+func requestRunOpts(req *http.Request) r.RunOpts {
+	return r.RunOpts{Context: req.Context()}
+}
+
+// queryFailed answers a failed query with a 500, except when the client has
+// gone away, which is not worth logging and leaves nobody to answer, and for
+// an index parameter naming no geo index, which is a 400.
+func queryFailed(w http.ResponseWriter, req *http.Request, err error) {
+	if req.Context().Err() != nil {
+		return
+	}
+	if errors.Is(err, ErrNoGeoIndex) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Println(err)
+	http.Error(w, "query failed", http.StatusInternalServerError)
+}
+
+// params returns q as the URL parameters of GET /nearest, so each query of
+// a batch goes through parseNearestParams and is checked and defaulted the
+// same way. A max_dist of 0 is left out, which means the default.
+func (q BatchQuery) params() url.Values {
+	v := url.Values{}
+	v.Set("lon", strconv.FormatFloat(q.Lon, 'g', -1, 64))
+	v.Set("lat", strconv.FormatFloat(q.Lat, 'g', -1, 64))
+	if q.MaxDist != 0 {
+		v.Set("max_dist", strconv.FormatFloat(q.MaxDist, 'g', -1, 64))
+	}
+	if q.Unit != "" {
+		v.Set("unit", q.Unit)
+	}
+	if q.Index != "" {
+		v.Set("index", q.Index)
+	}
+	return v
+}
+
+// writeJSONCached writes v like writeJSON with an ETag hashed from the
+// encoded body, and answers 304 Not Modified when it matches If-None-Match.
+// Hashing the result rather than the request means the ETag changes as soon
+// as the data does. Cache-Control: no-cache lets clients keep the response
+// but makes them revalidate it on every use.
+func writeJSONCached(w http.ResponseWriter, req *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Cannot encode response: ", err)
+		http.Error(w, "cannot encode response", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		log.Println("Cannot write response: ", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is "*".
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Cannot write response: ", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestRunOptsCarriesRequestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/nearest", nil).WithContext(ctx)
+	if got := requestRunOpts(req).Context; got != ctx {
+		t.Fatalf("requestRunOpts Context = %v, want the request's context", got)
+	}
+}
+
+// TestHandleNearestCancelled sends the same /nearest request with a live and
+// with a cancelled context: the first must answer, the second must abort its
+// query and, the client being gone, write nothing.
+func TestHandleNearestCancelled(t *testing.T) {
+	session := testSession(t)
+	defer func(drop bool) { *dropTable = drop }(*dropTable)
+	*dropTable = false
+	if err := createTable(session, io.Discard, *primaryKey, nil); err != nil {
+		t.Fatal("Cannot create table: ", err)
+	}
+	s := &server{session: session}
+	const target = "/nearest?lon=-122.423246&lat=37.779388"
+
+	rec := httptest.NewRecorder()
+	s.handleNearest(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("live request: status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errorsBefore := stats.errors.Load()
+	rec = httptest.NewRecorder()
+	s.handleNearest(rec, httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+	if got := stats.errors.Load() - errorsBefore; got != 1 {
+		t.Errorf("cancelled request: %d queries failed, want 1", got)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("cancelled request: wrote %q, want nothing", rec.Body)
+	}
+}
+
+func TestHandleNearestBatchRejectsInvalidQueries(t *testing.T) {
+	body := `[{"lon": 200, "lat": 0}, {"lon": 0, "lat": -91}, {"lon": 0, "lat": 0, "max_dist": -5}, {"lon": 0, "lat": 0, "unit": "parsec"}]`
+	rec := httptest.NewRecorder()
+	(&server{}).handleNearestBatch(rec, httptest.NewRequest(http.MethodPost, "/nearest/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var out []BatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	wantErrs := []string{"invalid lon", "invalid lat", "invalid max_dist", "invalid unit"}
+	if len(out) != len(wantErrs) {
+		t.Fatalf("got %d results, want %d", len(out), len(wantErrs))
+	}
+	for i, want := range wantErrs {
+		if out[i].Results != nil || !strings.HasPrefix(out[i].Error, want) {
+			t.Errorf("result %d = %+v, want an error starting with %q", i, out[i], want)
+		}
+	}
+}
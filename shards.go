@@ -0,0 +1,127 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// TableError is the failure of one table in a query over several tables.
+type TableError struct {
+	Table string
+	Err   error
+}
+
+func (e TableError) Error() string {
+	return fmt.Sprintf("table %s: %v", e.Table, e.Err)
+}
+
+// TableErrors collects the tables that failed in a query over several tables.
+type TableErrors []TableError
+
+func (e TableErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// nearestAcrossTables runs the nearest query against the geo index named
+// index of every table and merges the results, closest first. If
+// opts.MaxResults is set the merged slice is cut to that many rows, so it is
+// the global top N. Each table only has to return its own top N for that to
+// be right, since no row past a table's Nth can be in the global top N.
+//
+// Each table's index is checked with checkGeoIndex. A table that fails, for
+// example because it has no geo index of that name, doesn't abort the call:
+// the results of the other tables are returned along with a TableErrors
+// naming each failed table.
+func nearestAcrossTables(session *r.Session, index string, tables []string, p types.Point, opts r.GetNearestOpts) (*NearestResult, error) {
+	opts.Index = index
+	var perTable [][]*RecordWithDistance
+	var errs TableErrors
+	for _, table := range tables {
+		err := checkGeoIndex(session, table, index)
+		var rows []*RecordWithDistance
+		if err == nil {
+			rows, err = nearestInTable(session, table, p, opts)
+		}
+		if err != nil {
+			errs = append(errs, TableError{Table: table, Err: err})
+			continue
+		}
+		perTable = append(perTable, rows)
+	}
+	n, _ := opts.MaxResults.(int)
+	merged := mergeTopN(perTable, n)
+	if len(errs) > 0 {
+		return newNearestResult(p, opts, merged), errs
+	}
+	return newNearestResult(p, opts, merged), nil
+}
+
+// mergeTopN merges lists that are each sorted by distance, as GetNearest
+// returns them, into one sorted list of at most n rows, n <= 0 meaning all of
+// them. It is a k-way merge on a heap holding the head of every list, so it
+// touches only the rows it returns plus one head per list instead of sorting
+// everything. Rows at equal distances keep the order of lists, so the result
+// is deterministic.
+func mergeTopN(lists [][]*RecordWithDistance, n int) []*RecordWithDistance {
+	h := &mergeHeap{}
+	total := 0
+	for i, l := range lists {
+		if len(l) > 0 {
+			h.heads = append(h.heads, mergeHead{list: i})
+		}
+		total += len(l)
+	}
+	h.lists = lists
+	heap.Init(h)
+	if n <= 0 || n > total {
+		n = total
+	}
+	merged := make([]*RecordWithDistance, 0, n)
+	for len(merged) < n {
+		head := &h.heads[0]
+		merged = append(merged, lists[head.list][head.pos])
+		if head.pos++; head.pos < len(lists[head.list]) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return merged
+}
+
+type mergeHead struct{ list, pos int }
+
+// mergeHeap is a min-heap of list heads ordered by distance, then list index.
+type mergeHeap struct {
+	lists [][]*RecordWithDistance
+	heads []mergeHead
+}
+
+func (h *mergeHeap) Len() int { return len(h.heads) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.heads[i], h.heads[j]
+	da, db := h.lists[a.list][a.pos].Dist, h.lists[b.list][b.pos].Dist
+	if da != db {
+		return da < db
+	}
+	return a.list < b.list
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.heads = append(h.heads, x.(mergeHead)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	last := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return last
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeTopN(t *testing.T) {
+	row := func(name string, dist float64) *RecordWithDistance {
+		return &RecordWithDistance{Dist: dist, Doc: &Record{Name: name}}
+	}
+	lists := [][]*RecordWithDistance{
+		{row("a1", 1), row("a4", 4), row("a5", 5)},
+		{},
+		{row("c2", 2), row("c4", 4)},
+		{row("d0", 0), row("d4", 4), row("d9", 9)},
+	}
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		// a4, c4 and d4 tie and keep the order of their lists.
+		{0, []string{"d0", "a1", "c2", "a4", "c4", "d4", "a5", "d9"}},
+		{5, []string{"d0", "a1", "c2", "a4", "c4"}},
+		{1, []string{"d0"}},
+		{100, []string{"d0", "a1", "c2", "a4", "c4", "d4", "a5", "d9"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, row := range mergeTopN(lists, tt.n) {
+			got = append(got, row.Doc.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeTopN(lists, %d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+	if got := mergeTopN(nil, 3); len(got) != 0 {
+		t.Errorf("mergeTopN(nil, 3) = %v, want no rows", got)
+	}
+}
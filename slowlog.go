@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var slowQueryMs = flag.Int("slow-query-ms", 0, "log nearest queries whose run and decode take longer than this many milliseconds, 0 disables")
+
+// logSlowQuery logs a nearest query around p that took took and returned n
+// rows, if that is over -slow-query-ms. The opts are logged as given, so a
+// MaxResults or MaxDist left to the server's default shows as <nil>.
+func logSlowQuery(p types.Point, opts r.GetNearestOpts, took time.Duration, n int) {
+	if *slowQueryMs <= 0 || took <= time.Duration(*slowQueryMs)*time.Millisecond {
+		return
+	}
+	log.Printf("Warning: slow nearest query at lon %v, lat %v took %v for %d results (index %v, max_dist %v %v, max_results %v)",
+		p.Lon, p.Lat, took, n, opts.Index, opts.MaxDist, opts.Unit, opts.MaxResults)
+}
+
+// runNearest runs query, a nearest query around p with opts, and decodes its
+// rows with decodeAll. The time from Run to the last row decoded goes to
+// logSlowQuery, so every nearest function running its own query through
+// here is covered by -slow-query-ms.
+func runNearest[T any](session *r.Session, query r.Term, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) ([]T, error) {
+	start := time.Now()
+	res, err := runQuery(session, query, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	var rows []T
+	if err = decodeAll(res, &rows); err != nil {
+		return nil, err
+	}
+	logSlowQuery(p, opts, time.Since(start), len(rows))
+	return rows, nil
+}
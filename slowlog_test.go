@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+func TestLogSlowQuery(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+	defer func(ms int) { *slowQueryMs = ms }(*slowQueryMs)
+
+	p := types.Point{Lon: 1, Lat: 2}
+	opts := r.GetNearestOpts{Index: indexName, MaxDist: 500, MaxResults: 10, Unit: "km"}
+	tests := []struct {
+		ms      int
+		took    time.Duration
+		wantLog bool
+	}{
+		{0, time.Hour, false},
+		{200, 200 * time.Millisecond, false},
+		{200, 201 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		*slowQueryMs = tt.ms
+		logSlowQuery(p, opts, tt.took, 3)
+		if got := buf.Len() > 0; got != tt.wantLog {
+			t.Errorf("-slow-query-ms %d, took %v: logged %q, want a line: %v", tt.ms, tt.took, buf.String(), tt.wantLog)
+		}
+		if !tt.wantLog {
+			continue
+		}
+		for _, want := range []string{"lon 1, lat 2", "took 201ms", "3 results", "index area", "max_dist 500 km", "max_results 10"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("logged %q, want %q in it", buf.String(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// NearestSnapshot is a nearest result together with the server time it was
+// read at.
+type NearestSnapshot struct {
+	*NearestResult
+	At time.Time `json:"at"`
+}
+
+type snapshotRow struct {
+	At      time.Time             `gorethink:"at"`
+	Records []*RecordWithDistance `gorethink:"records"`
+}
+
+// nearestSnapshot runs GetNearest on index with ReadMode "majority" and
+// returns the rows together with r.Now() from the same query. r.Now() is
+// evaluated once per query, so At is the server's clock when the query ran.
+//
+// What this guarantees: every row was acknowledged by a majority of the
+// table's replicas, so nothing returned can be lost to a failover, and the
+// reads and the timestamp come from one query rather than two round trips.
+//
+// What it doesn't: RethinkDB has no multi-document read transactions, so the
+// rows aren't a point-in-time snapshot of the table. A write that lands while
+// GetNearest is scanning the index may or may not be seen, and At is only
+// the time the query started, not a version the rows are consistent at.
+// ReadMode also overrides any ReadMode set in runOpts.
+func nearestSnapshot(session *r.Session, index string, p types.Point, opts r.GetNearestOpts, runOpts ...r.RunOpts) (*NearestSnapshot, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts.Index = index
+	var ro r.RunOpts
+	if len(runOpts) > 0 {
+		ro = runOpts[0]
+	}
+	ro.ReadMode = "majority"
+
+	var row snapshotRow
+	query := r.Expr(map[string]interface{}{
+		"at":      r.Now(),
+		"records": r.Table(tableName).GetNearest(p, opts).Filter(resultNotDeleted()),
+	})
+	res, err := runQuery(session, query, ro)
+	if err != nil {
+		return nil, err
+	}
+	if err = res.One(&row); err != nil {
+		return nil, err
+	}
+	return &NearestSnapshot{NearestResult: newNearestResult(p, opts, row.Records), At: row.At}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// QuerySpec is a nearest query saved as data, so it can be stored in a file
+// and replayed later with runSpec.
+type QuerySpec struct {
+	Point      types.Point
+	Index      string
+	MaxDist    float64
+	MaxResults int
+	Unit       string
+	// Filters keeps only records whose field equals the value, e.g.
+	// {"category": "cafe"}.
+	Filters map[string]string
+}
+
+// querySpecJSON is the wire form of QuerySpec, with the point spelled out
+// as lon/lat rather than types.Point's field names.
+type querySpecJSON struct {
+	Lon        float64           `json:"lon"`
+	Lat        float64           `json:"lat"`
+	Index      string            `json:"index,omitempty"`
+	MaxDist    float64           `json:"max_dist,omitempty"`
+	MaxResults int               `json:"max_results,omitempty"`
+	Unit       string            `json:"unit,omitempty"`
+	Filters    map[string]string `json:"filters,omitempty"`
+}
+
+func (s QuerySpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(querySpecJSON{
+		Lon:        s.Point.Lon,
+		Lat:        s.Point.Lat,
+		Index:      s.Index,
+		MaxDist:    s.MaxDist,
+		MaxResults: s.MaxResults,
+		Unit:       s.Unit,
+		Filters:    s.Filters,
+	})
+}
+
+func (s *QuerySpec) UnmarshalJSON(b []byte) error {
+	var j querySpecJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if j.Unit != "" && !validUnit(j.Unit) {
+		return fmt.Errorf("invalid unit %q in query spec", j.Unit)
+	}
+	*s = QuerySpec{
+		Point:      types.Point{Lon: j.Lon, Lat: j.Lat},
+		Index:      j.Index,
+		MaxDist:    j.MaxDist,
+		MaxResults: j.MaxResults,
+		Unit:       j.Unit,
+		Filters:    j.Filters,
+	}
+	return nil
+}
+
+// index is the geo index the spec names, indexName if none.
+func (s QuerySpec) index() string {
+	if s.Index == "" {
+		return indexName
+	}
+	return s.Index
+}
+
+// opts returns the GetNearestOpts for the spec, filling in the defaults for
+// anything left unset. The index is left to runSpec.
+func (s QuerySpec) opts() r.GetNearestOpts {
+	opts := r.GetNearestOpts{MaxDist: float64(defaultMaxDist), MaxResults: defaultMaxResults, Unit: defaultUnit}
+	if s.MaxDist > 0 {
+		opts.MaxDist = s.MaxDist
+	}
+	if s.MaxResults > 0 {
+		opts.MaxResults = s.MaxResults
+	}
+	if s.Unit != "" {
+		opts.Unit = s.Unit
+	}
+	return opts
+}
+
+// runSpec runs the nearest query described by spec on the geo index named
+// index, which replaces spec.Index; to replay a saved spec as it was, pass
+// spec.index().
+func runSpec(session *r.Session, index string, spec QuerySpec, runOpts ...r.RunOpts) ([]*RecordWithDistance, error) {
+	if err := checkGeoIndex(session, tableName, index); err != nil {
+		return nil, err
+	}
+	opts := spec.opts()
+	opts.Index = index
+	query := nearestQuery(tableName, spec.Point, opts)
+	// sorted so the same spec always builds the same query
+	fields := make([]string, 0, len(spec.Filters))
+	for field := range spec.Filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		query = query.Filter(r.Row.Field("doc").Field(field).Eq(spec.Filters[field]))
+	}
+
+	rows, err := runNearest[*RecordWithDistance](session, query, spec.Point, opts, runOpts...)
+	if err != nil {
+		return nil, err
+	}
+	setRanks(rows)
+	return rows, nil
+}
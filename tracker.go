@@ -0,0 +1,121 @@
+package main
+
+import (
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// NearbyDelta is how the nearby set changed after a move: the records that
+// came within range and the ones that dropped out of it. Err is set instead
+// when the query for Position failed.
+type NearbyDelta struct {
+	Position types.Point
+	Entered  []*Record
+	Left     []*Record
+	Err      error
+}
+
+// nearestTracker follows a moving query point. Each Move is debounced: the
+// nearest query runs once the point has been still for the debounce period,
+// and only the latest position counts. The result is diffed by id against
+// the previous nearby set and sent on Results when anything changed.
+type nearestTracker struct {
+	session  *r.Session
+	index    string
+	opts     r.GetNearestOpts
+	debounce time.Duration
+
+	moves   chan types.Point
+	results chan NearbyDelta
+	done    chan struct{}
+}
+
+func newNearestTracker(session *r.Session, index string, opts r.GetNearestOpts, debounce time.Duration) *nearestTracker {
+	t := &nearestTracker{
+		session:  session,
+		index:    index,
+		opts:     opts,
+		debounce: debounce,
+		moves:    make(chan types.Point),
+		results:  make(chan NearbyDelta, 16),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Move reports a new position.
+func (t *nearestTracker) Move(p types.Point) {
+	select {
+	case t.moves <- p:
+	case <-t.done:
+	}
+}
+
+// Results delivers the changes to the nearby set. It is closed by Close.
+func (t *nearestTracker) Results() <-chan NearbyDelta {
+	return t.results
+}
+
+// Close stops the tracker. Moves made after Close are dropped.
+func (t *nearestTracker) Close() {
+	close(t.done)
+}
+
+func (t *nearestTracker) run() {
+	defer close(t.results)
+	nearby := map[string]*Record{}
+	var pending types.Point
+	timer := time.NewTimer(t.debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case p := <-t.moves:
+			pending = p
+			timer.Reset(t.debounce)
+		case <-timer.C:
+			delta := t.update(pending, nearby)
+			if delta.Err == nil && len(delta.Entered) == 0 && len(delta.Left) == 0 {
+				continue
+			}
+			select {
+			case t.results <- delta:
+			case <-t.done:
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// update queries around p and moves nearby to the new set, returning what
+// changed.
+func (t *nearestTracker) update(p types.Point, nearby map[string]*Record) NearbyDelta {
+	delta := NearbyDelta{Position: p}
+	res, err := nearest(t.session, t.index, p, t.opts)
+	if err != nil {
+		delta.Err = err
+		return delta
+	}
+	current := make(map[string]*Record, len(res.Records))
+	for _, row := range res.Records {
+		current[row.Doc.ID] = row.Doc
+		if _, ok := nearby[row.Doc.ID]; !ok {
+			delta.Entered = append(delta.Entered, row.Doc)
+		}
+	}
+	for id, rec := range nearby {
+		if _, ok := current[id]; !ok {
+			delta.Left = append(delta.Left, rec)
+		}
+		delete(nearby, id)
+	}
+	for id, rec := range current {
+		nearby[id] = rec
+	}
+	return delta
+}
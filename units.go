@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var distUnits = flag.String("units", "", `also give every nearest result's distance in these units, a comma separated list of "m", "mi" and "km"; the query still runs in one unit`)
+
+// outputUnits is -units parsed, set by main.
+var outputUnits []string
+
+// MultiUnitDist is a result's distance in the units of -units. Each is
+// converted client-side from the distance the query returned, in the query's
+// unit, so asking for more units costs no extra query. Units not asked for
+// are nil and left out of the JSON.
+type MultiUnitDist struct {
+	DistMeters *float64 `json:"DistMeters,omitempty"`
+	DistMiles  *float64 `json:"DistMiles,omitempty"`
+	DistKm     *float64 `json:"DistKm,omitempty"`
+}
+
+// parseUnits parses the -units list.
+func parseUnits(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var units []string
+	for _, unit := range strings.Split(s, ",") {
+		switch unit = strings.TrimSpace(unit); unit {
+		case "m", "mi", "km":
+			units = append(units, unit)
+		default:
+			return nil, fmt.Errorf("unknown unit %q in -units, want m, mi or km", unit)
+		}
+	}
+	return units, nil
+}
+
+// multiUnitDist converts dist, in unit, to each of units.
+func multiUnitDist(dist float64, unit string, units []string) (*MultiUnitDist, error) {
+	var m MultiUnitDist
+	for _, to := range units {
+		d, err := convertDistance(dist, unit, to)
+		if err != nil {
+			return nil, err
+		}
+		switch to {
+		case "m":
+			m.DistMeters = &d
+		case "mi":
+			m.DistMiles = &d
+		case "km":
+			m.DistKm = &d
+		}
+	}
+	return &m, nil
+}
+
+// rounded returns m with every distance rounded by roundDist.
+func (m MultiUnitDist) rounded() *MultiUnitDist {
+	for _, d := range []**float64{&m.DistMeters, &m.DistMiles, &m.DistKm} {
+		if *d != nil {
+			v := roundDist(**d)
+			*d = &v
+		}
+	}
+	return &m
+}
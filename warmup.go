@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+var (
+	warmup       = flag.Bool("warmup", true, "serve: run warm-up queries before accepting requests; -warmup=false starts right away")
+	warmupPoints = flag.String("warmup-points", "-122.4153346282659,37.77874812639591", `serve: "lon,lat;lon,lat" points the warm-up queries run around`)
+)
+
+// warmUp runs a default nearest query around each of points, so the geo
+// index and documents they touch are paged into the server's cache before
+// the first real request. serve calls it before it starts listening, so
+// nothing is answered, and readiness checks fail, until it is done. Warming
+// is best effort: a failed query is logged and the rest still run.
+func warmUp(session *r.Session, points []types.Point) {
+	start := time.Now()
+	opts := r.GetNearestOpts{MaxDist: float64(defaultMaxDist), MaxResults: defaultMaxResults}
+	for _, p := range points {
+		if _, err := nearest(session, indexName, p, opts); err != nil {
+			log.Printf("Warm-up query at lon %v, lat %v failed: %v", p.Lon, p.Lat, err)
+		}
+	}
+	log.Printf("Warmed up with %d queries in %v", len(points), time.Since(start))
+}
+
+// parseWarmupPoints parses "lon,lat;lon,lat".
+func parseWarmupPoints(s string) ([]types.Point, error) {
+	var points []types.Point
+	for _, pair := range strings.Split(s, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		lonLat := strings.Split(pair, ",")
+		if len(lonLat) != 2 {
+			return nil, fmt.Errorf("invalid warm-up point %q, want lon,lat", pair)
+		}
+		lon, err1 := strconv.ParseFloat(strings.TrimSpace(lonLat[0]), 64)
+		lat, err2 := strconv.ParseFloat(strings.TrimSpace(lonLat[1]), 64)
+		p := types.Point{Lon: lon, Lat: lat}
+		if err1 != nil || err2 != nil || !validPoint(p) {
+			return nil, fmt.Errorf("invalid warm-up point %q, want lon,lat", pair)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+	"gopkg.in/gorethink/gorethink.v3/types"
+)
+
+// Overflow policies of watchNearest, for when its buffer is full.
+const (
+	// feedBlock waits for the consumer. No change is lost on the client,
+	// but the changefeed stops being read, and once the server's own queue
+	// for it fills up the server drops changes instead.
+	feedBlock = "block"
+	// feedDropOldest discards the oldest buffered change to make room. This
+	// is the default: the feed keeps flowing and the consumer sees the most
+	// recent changes, which for records moving around are the ones that
+	// matter.
+	feedDropOldest = "drop-oldest"
+	// feedDropNewest discards the change that doesn't fit, keeping the
+	// buffered ones; a terminal Err still makes room for itself.
+	feedDropNewest = "drop-newest"
+)
+
+// NearbyChange is a change to a record within range of a watched point, as
+// the changefeed reports it: Old is nil for an insert, New for a delete. Err
+// is set instead when the feed failed; it is the last change sent.
+type NearbyChange struct {
+	Old *Record `gorethink:"old_val"`
+	New *Record `gorethink:"new_val"`
+	Err error   `gorethink:"-"`
+}
+
+// feedOptions controls how watchNearest buffers changes for a slow consumer.
+type feedOptions struct {
+	// Buffer is how many changes are held for the consumer, at least 1.
+	Buffer int
+	// Overflow is the policy once Buffer changes are waiting: feedBlock,
+	// feedDropOldest or feedDropNewest. Empty means feedDropOldest.
+	Overflow string
+}
+
+// watchNearest follows the changes to records whose new position is within
+// maxMeters of p, through a changefeed filtered on the server, until ctx is
+// done. A change moving a record out of range is not reported, since its new
+// position is what is filtered on. Changes are buffered up to opts.Buffer
+// and handled past that by opts.Overflow, so a burst of writes nearby can't
+// grow memory without bound; every dropped change counts towards
+// geo_feed_dropped_total on /metrics. The channel is closed when the feed
+// ends.
+func watchNearest(ctx context.Context, session *r.Session, p types.Point, maxMeters float64, opts feedOptions) (<-chan NearbyChange, error) {
+	if opts.Buffer < 1 {
+		return nil, fmt.Errorf("invalid feed buffer %d, want 1 or more", opts.Buffer)
+	}
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = feedDropOldest
+	case feedBlock, feedDropOldest, feedDropNewest:
+	default:
+		return nil, fmt.Errorf("unknown overflow policy %q, want %s, %s or %s", opts.Overflow, feedBlock, feedDropOldest, feedDropNewest)
+	}
+	query := r.Table(tableName).Changes().
+		Filter(r.Row.Field("new_val").Field(indexName).Distance(p).Le(maxMeters))
+	res, err := runQuery(session, query, r.RunOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan NearbyChange, opts.Buffer)
+	go func() {
+		defer close(changes)
+		defer res.Close()
+		var change NearbyChange
+		for res.Next(&change) {
+			if !offer(ctx, changes, change, opts.Overflow) {
+				return
+			}
+			change = NearbyChange{}
+		}
+		if err := res.Err(); err != nil && ctx.Err() == nil {
+			offerErr(ctx, changes, err, opts.Overflow)
+		}
+	}()
+	return changes, nil
+}
+
+// offerErr queues the terminal error of the feed. It is the last change and
+// the only sign the feed failed rather than ended, so even under
+// feedDropNewest it evicts the oldest buffered change instead of being
+// dropped itself.
+func offerErr(ctx context.Context, changes chan NearbyChange, err error, overflow string) {
+	if overflow == feedDropNewest {
+		overflow = feedDropOldest
+	}
+	offer(ctx, changes, NearbyChange{Err: err}, overflow)
+}
+
+// offer queues change on changes by the overflow policy. It returns false
+// if ctx was done while blocked. Only the feed goroutine sends on changes, so
+// once a slot is freed the send can't block.
+func offer(ctx context.Context, changes chan NearbyChange, change NearbyChange, overflow string) bool {
+	select {
+	case changes <- change:
+		return true
+	default:
+	}
+	switch overflow {
+	case feedBlock:
+		select {
+		case changes <- change:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	case feedDropOldest:
+		select {
+		case <-changes:
+			stats.feedDropped.Add(1)
+		default:
+			// The consumer took one meanwhile.
+		}
+		changes <- change
+	default:
+		stats.feedDropped.Add(1)
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOfferErrUnderDropNewest(t *testing.T) {
+	changes := make(chan NearbyChange, 2)
+	first, second := &Record{Name: "first"}, &Record{Name: "second"}
+	ctx := context.Background()
+	offer(ctx, changes, NearbyChange{New: first}, feedDropNewest)
+	offer(ctx, changes, NearbyChange{New: second}, feedDropNewest)
+	offer(ctx, changes, NearbyChange{New: &Record{Name: "dropped"}}, feedDropNewest)
+
+	feedErr := errors.New("feed failed")
+	offerErr(ctx, changes, feedErr, feedDropNewest)
+	close(changes)
+
+	var got []NearbyChange
+	for change := range changes {
+		got = append(got, change)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d changes, want 2", len(got))
+	}
+	if got[0].New != second {
+		t.Errorf("first change = %+v, want the second record, the oldest having been evicted", got[0])
+	}
+	if got[1].Err != feedErr {
+		t.Errorf("last change Err = %v, want %v", got[1].Err, feedErr)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var writeLogPath = flag.String("write-log", "", "append every insert, update and delete to this JSON Lines file, for audit and replay")
+
+// writeLog is the -write-log file, nil when it isn't set. main opens it.
+var writeLog *writeLogger
+
+// writeLogEntry is one line of the write log. ID is empty for an insert that
+// failed before the server assigned one; Error is set when the write failed,
+// so the log also shows what was attempted.
+type writeLogEntry struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	ID    string    `json:"id"`
+	Error string    `json:"error,omitempty"`
+}
+
+// writeLogger appends entries to a file, one JSON object per line. Each
+// entry is a single unbuffered write, so it is in the file as soon as
+// logWrite returns even if the program dies right after.
+type writeLogger struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openWriteLog(path string) (*writeLogger, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &writeLogger{f: f}, nil
+}
+
+func (l *writeLogger) close() error {
+	return l.f.Close()
+}
+
+// logWrite records a write of op ("insert", "update", "delete" or "upsert")
+// on the record id, which returned err. It does nothing without -write-log.
+// The mutation helpers call it after the write and return the write's own
+// result whatever happens here: a failure to log is reported with log, not
+// returned, so a write that went through never looks like it failed.
+func logWrite(op, id string, err error) {
+	if writeLog == nil {
+		return
+	}
+	entry := writeLogEntry{Time: time.Now().UTC(), Op: op, ID: id}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	b, jerr := json.Marshal(entry)
+	if jerr == nil {
+		writeLog.mu.Lock()
+		_, jerr = writeLog.f.Write(append(b, '\n'))
+		writeLog.mu.Unlock()
+	}
+	if jerr != nil {
+		log.Printf("Cannot write %s of %q to the write log: %v", op, id, jerr)
+	}
+}